@@ -144,8 +144,55 @@ func flagsInitialization(expertMode bool) []cli.Flag {
 		&cli.StringFlag{
 			Name:     "proxy-dst-server",
 			Category: "Proxy settings",
-			Usage:    "destination server",
-			Value:    "127.0.0.1:36080",
+			Usage: `destination server; multiple comma-separated upstreams are supported,
+			upstream weight can be defined after slash; Example: 10.0.0.1:36080/2,10.0.0.2:36080`,
+			Value: "127.0.0.1:36080",
+		},
+		&cli.StringFlag{
+			Name:     "proxy-balancer",
+			Category: "Proxy settings",
+			Usage: `balancing strategy for multiple upstreams; round-robin, least-conn, hash;
+			hash strategy uses consistent hashing on the request cache key`,
+			Value: "round-robin",
+		},
+		&cli.StringFlag{
+			Name:     "proxy-transport",
+			Category: "Proxy settings",
+			Usage: `upstream protocol; http, fastcgi; fastcgi talks to PHP-FPM directly,
+			unix sockets are supported in proxy-dst-server as unix:/run/php/php-fpm.sock`,
+			Value: "http",
+		},
+		&cli.StringFlag{
+			Name:     "proxy-fastcgi-document-root",
+			Category: "Proxy settings",
+			Usage:    "PHP-FPM document root; SCRIPT_FILENAME is built as document root + request path",
+			Value:    "/var/www/html",
+		},
+		&cli.StringFlag{
+			Name:     "proxy-fastcgi-params",
+			Category: "Proxy settings",
+			Usage: `additional or overridden FastCGI params;
+			format - NAME=value,NAME=value; Example: HTTPS=on,SCRIPT_FILENAME=/var/www/api/index.php`,
+			Hidden: expertMode,
+		},
+		&cli.IntFlag{
+			Name:     "proxy-fastcgi-max-response-size",
+			Category: "Proxy settings",
+			Usage: `max size of FastCGI response (headers and body) in bytes;
+			the upstream request fails if it's exceeded; 0 value means no size limit`,
+			Value:  16 * 1024 * 1024,
+			Hidden: expertMode,
+		},
+		&cli.StringFlag{
+			Name:     "proxy-whitelists-file",
+			Category: "Proxy settings",
+			Usage: `path to JSON file with args, queries and bypass request whitelists;
+			reloaded on SIGHUP and by /internal/cache/whitelists/reload; built-in lists are used if empty;
+			format - {"args": ["query", "id"], "queries": ["release"], "bypass": []};
+			optional "schema" section defines arg values validation per query (* for all queries) -
+			{"release": {"id": {"type": "int", "min": 1, "required": true}}}; types - int, string, bool;
+			args of the query schema could be omitted in "args", then they are allowed for the query only;
+			built-in random_release filters schema is kept unless the file defines its own "random_release" schema`,
 		},
 		&cli.StringFlag{
 			Name:     "proxy-dst-host",
@@ -196,6 +243,146 @@ func flagsInitialization(expertMode bool) []cli.Flag {
 			Value:    0,
 		},
 
+		// proxy settings : upstream tls
+		&cli.BoolFlag{
+			Name:               "proxy-tls-enable",
+			Category:           "Proxy TLS",
+			Usage:              "use HTTPS for upstream connections",
+			DisableDefaultText: true,
+		},
+		&cli.StringFlag{
+			Name:     "proxy-tls-ca",
+			Category: "Proxy TLS",
+			Usage:    "path to PEM CA bundle for upstream certificates verification; system CA is used if empty",
+		},
+		&cli.StringFlag{
+			Name:     "proxy-tls-server-name",
+			Category: "Proxy TLS",
+			Usage:    "SNI and verification server name override; upstream host is used if empty",
+		},
+		&cli.StringFlag{
+			Name:     "proxy-tls-cert",
+			Category: "Proxy TLS",
+			Usage:    "path to PEM client certificate for mTLS",
+		},
+		&cli.StringFlag{
+			Name:     "proxy-tls-key",
+			Category: "Proxy TLS",
+			Usage:    "path to PEM client certificate key for mTLS",
+		},
+		&cli.StringFlag{
+			Name:     "proxy-tls-min-version",
+			Category: "Proxy TLS",
+			Usage:    "1.0, 1.1, 1.2, 1.3",
+			Value:    "1.2",
+		},
+		&cli.DurationFlag{
+			Name:     "proxy-tls-reload-frequency",
+			Category: "Proxy TLS",
+			Usage:    "how often CA and client certificate files are checked for changes; 0 - disabled",
+			Value:    1 * time.Minute,
+		},
+		&cli.BoolFlag{
+			Name:               "proxy-tls-insecure-skip-verify",
+			Category:           "Proxy TLS",
+			Usage:              "skip upstream certificate verification; USE CAREFULLY!",
+			Hidden:             expertMode,
+			DisableDefaultText: true,
+		},
+
+		// proxy settings : retries
+		&cli.IntFlag{
+			Name:     "proxy-retry-attempts",
+			Category: "Proxy retries",
+			Usage:    "max retries of read-only upstream requests after 5XX or transport errors; 0 - disabled",
+			Value:    0,
+		},
+		&cli.StringFlag{
+			Name:     "proxy-retry-queries",
+			Category: "Proxy retries",
+			Usage: `read-only apiv1 queries which can be retried; mutating queries (auth_*, favorites, etc.)
+			and requests without query are never retried`,
+			Value: "config,app_update,teams,torrent,info,franchises,release,list,schedule,feed,genres,years,youtube,catalog,search",
+		},
+		&cli.StringFlag{
+			Name:     "proxy-retry-upstream",
+			Category: "Proxy retries",
+			Usage:    "upstream for retries; same, different (falls back to the same if there is no another one)",
+			Value:    "different",
+		},
+		&cli.DurationFlag{
+			Name:     "proxy-retry-backoff",
+			Category: "Proxy retries",
+			Usage:    "base delay of exponential backoff with jitter between retries",
+			Value:    25 * time.Millisecond,
+		},
+		&cli.DurationFlag{
+			Name:     "proxy-retry-backoff-max",
+			Category: "Proxy retries",
+			Value:    250 * time.Millisecond,
+		},
+		&cli.IntFlag{
+			Name:     "proxy-retry-budget",
+			Category: "Proxy retries",
+			Usage:    "max retries as a percentage of upstream requests within proxy-retry-budget-window",
+			Value:    10,
+		},
+		&cli.DurationFlag{
+			Name:     "proxy-retry-budget-window",
+			Category: "Proxy retries",
+			Value:    10 * time.Second,
+			Hidden:   expertMode,
+		},
+
+		// proxy settings : upstream health checks
+		&cli.BoolFlag{
+			Name:               "proxy-healthcheck-enable",
+			Category:           "Proxy health checks",
+			Usage:              "poll every upstream with apiv1 query and open its circuit on failures",
+			DisableDefaultText: true,
+		},
+		&cli.StringFlag{
+			Name:     "proxy-healthcheck-path",
+			Category: "Proxy health checks",
+			Value:    "/public/api/index.php",
+		},
+		&cli.StringFlag{
+			Name:     "proxy-healthcheck-query",
+			Category: "Proxy health checks",
+			Usage:    "urlencoded apiv1 request body for health checks",
+			Value:    "query=config",
+		},
+		&cli.DurationFlag{
+			Name:     "proxy-healthcheck-interval",
+			Category: "Proxy health checks",
+			Value:    5 * time.Second,
+		},
+		&cli.DurationFlag{
+			Name:     "proxy-healthcheck-timeout",
+			Category: "Proxy health checks",
+			Value:    3 * time.Second,
+		},
+		&cli.IntFlag{
+			Name:     "proxy-circuit-failures",
+			Category: "Proxy health checks",
+			Usage: `consecutive upstream failures (5XX, transport errors or failed health checks)
+			after which upstream's circuit will be opened; 0 - circuit breaker is disabled`,
+			Value: 5,
+		},
+		&cli.DurationFlag{
+			Name:     "proxy-circuit-open-timeout",
+			Category: "Proxy health checks",
+			Usage:    "time while the opened circuit gets no traffic before half-open state",
+			Value:    30 * time.Second,
+		},
+		&cli.IntFlag{
+			Name:     "proxy-circuit-halfopen-probes",
+			Category: "Proxy health checks",
+			Usage:    "successful requests in half-open state required for circuit closing",
+			Value:    3,
+			Hidden:   expertMode,
+		},
+
 		// cache settings
 		&cli.StringFlag{
 			Name:     "cache-api-secret",
@@ -212,9 +399,171 @@ func flagsInitialization(expertMode bool) []cli.Flag {
 		&cli.DurationFlag{
 			Name:     "cache-life-window",
 			Category: "Cache settings",
-			Usage:    "time after which entry can be evicted",
+			Usage:    "default time after which entry is expired and can be evicted",
 			Value:    10 * time.Minute,
 		},
+		&cli.StringFlag{
+			Name:     "cache-ttl-policy",
+			Category: "Cache settings",
+			Usage: `entry lifetimes by apiv1 request args, cache-life-window is used for unmatched requests;
+			format - args|ttl; the most specific rule wins, * matches any arg value;
+			Example: query=genres|24h,query=years|24h,query=feed|1m,query=release&id=*|30m`,
+		},
+		&cli.StringFlag{
+			Name:     "cache-encodings",
+			Category: "Cache settings",
+			Usage: `content encodings of cached responses negotiated by Accept-Encoding header;
+			in order of server preference, br, zstd and gzip are supported; if empty, responses are not encoded;
+			encoded variants are stored next to the cached entry; Example: br,zstd,gzip`,
+		},
+		&cli.BoolFlag{
+			Name:               "cache-encodings-eager",
+			Category:           "Cache settings",
+			Usage:              "build encoded variants when the entry is cached instead of the first use",
+			DisableDefaultText: true,
+		},
+		&cli.DurationFlag{
+			Name:     "cache-stale-window",
+			Category: "Cache settings",
+			Usage: `grace time after cache-life-window while expired entries are kept in cache;
+			stale entries are used by cache-stale-if-error and cache-stale-while-revalidate modes only;
+			0 - expired entries are evicted immediately`,
+			Value: 0,
+		},
+		&cli.BoolFlag{
+			Name:               "cache-stale-if-error",
+			Category:           "Cache settings",
+			Usage:              "respond with stale entry if upstream has failed (5XX or transport error)",
+			DisableDefaultText: true,
+		},
+		&cli.BoolFlag{
+			Name:     "cache-stale-while-revalidate",
+			Category: "Cache settings",
+			Usage: `respond with stale entry immediately and refresh it with one background request;
+			stale responses are marked with X-Alice-Cache: STALE header`,
+			DisableDefaultText: true,
+		},
+		&cli.StringFlag{
+			Name:     "cache-key-normalize",
+			Category: "Cache settings",
+			Usage: `cache key normalization rules; upstream receives the original args anyway;
+			actions - drop:arg, default:arg=value (drop if equals), trim:arg, lower:arg;
+			Example: drop:csrf,drop:deviceId,default:page=1,trim:search,lower:search`,
+		},
+		&cli.StringFlag{
+			Name:     "cache-key-headers",
+			Category: "Cache settings",
+			Usage: `request headers which normalized values are added to the cache key of the query;
+			format - query|header|normalizer, * matches all queries; normalizers - raw, lower, lang, version, uaclass;
+			Example: *|X-App-Version|version,schedule|Accept-Language|lang`,
+		},
+		&cli.BoolFlag{
+			Name:     "cache-get-share-post",
+			Category: "Cache settings",
+			Usage: `GET (and HEAD) requests with args in the query string share cache entries
+			with POST requests with the same args`,
+			DisableDefaultText: true,
+		},
+		&cli.BoolFlag{
+			Name:     "cache-key-method",
+			Category: "Cache settings",
+			Usage: `add the request method to the cache key; HEAD requests share entries with GET ones;
+			could not be used with cache-get-share-post`,
+			DisableDefaultText: true,
+		},
+		&cli.StringFlag{
+			Name:     "cache-route-groups",
+			Category: "Cache settings",
+			Usage: `comma-separated cached route groups in format name=prefix, every group has its own
+			cache key namespace; key format - v2|<group>|<path>|<method or *>|<args>...`,
+			Value:  "apiv1=/public/api",
+			Hidden: expertMode,
+		},
+		&cli.BoolFlag{
+			Name:     "cache-session-enable",
+			Category: "Cache settings",
+			Usage: `cache per-user queries (favorites, etc.) with the hashed session in the cache key;
+			mutating actions invalidate all cached entries of the session`,
+			DisableDefaultText: true,
+		},
+		&cli.StringFlag{
+			Name:     "cache-session-cookie",
+			Category: "Cache settings",
+			Usage:    "session cookie name",
+			Value:    "PHPSESSID",
+		},
+		&cli.StringFlag{
+			Name:     "cache-session-arg",
+			Category: "Cache settings",
+			Usage:    "session token argument name; it has priority over the cookie and must be whitelisted",
+		},
+		&cli.StringFlag{
+			Name:     "cache-session-queries",
+			Category: "Cache settings",
+			Usage:    "comma-separated queries which are cached per session",
+			Value:    "favorites",
+		},
+		&cli.StringFlag{
+			Name:     "cache-session-invalidate-actions",
+			Category: "Cache settings",
+			Usage:    "comma-separated action values which invalidate all cached entries of the session",
+			Value:    "add,delete",
+		},
+		&cli.DurationFlag{
+			Name:     "cache-session-ttl",
+			Category: "Cache settings",
+			Usage:    "ttl for per-session cache entries",
+			Value:    1 * time.Minute,
+		},
+		&cli.StringFlag{
+			Name:     "cache-cookie-policy",
+			Category: "Cache settings",
+			Usage: `caching policy for upstream responses with Set-Cookie headers; bypass, strip;
+			bypass - never cache such responses; strip - drop cookies from cache-cookie-strip-list
+			and cache the response if there are no other cookies`,
+			Value: "bypass",
+		},
+		&cli.StringFlag{
+			Name:     "cache-cookie-strip-list",
+			Category: "Cache settings",
+			Usage:    "comma-separated cookie names which are safe to drop for caching; Example: _ga,lang",
+		},
+		&cli.StringFlag{
+			Name:     "cache-control-trusted-cidrs",
+			Category: "Cache settings",
+			Usage: `comma-separated networks which are allowed to send X-CacheKey-* and X-Cache-Bypass
+			headers; headers from other clients are ignored; if the direct peer is one of http-trusted-proxies,
+			the client address from http-realip-header is checked, otherwise the peer address is checked`,
+		},
+		&cli.StringFlag{
+			Name:     "cache-control-secret",
+			Category: "Cache settings",
+			Usage: `HMAC-SHA256 secret for X-Cache-Signature header which allows custom headers from
+			any client; format - <unix ts>:<hex hmac>, the message is "<unix ts>\n", then
+			"<header>:<value>\n" for every present header in order override, prefix, suffix, bypass
+			and then sorted urlencoded request args; empty value disables signatures`,
+			Hidden: expertMode,
+		},
+		&cli.DurationFlag{
+			Name:     "cache-control-signature-ttl",
+			Category: "Cache settings",
+			Usage:    "allowed clock skew of X-Cache-Signature timestamp",
+			Value:    5 * time.Minute,
+			Hidden:   expertMode,
+		},
+		&cli.BoolFlag{
+			Name:     "cache-coalescing-enable",
+			Category: "Cache settings",
+			Usage: `collapse concurrent cache misses of the same key into the one upstream request;
+			all waiting requests will be answered from its cached result`,
+			DisableDefaultText: true,
+		},
+		&cli.DurationFlag{
+			Name:     "cache-coalescing-timeout",
+			Category: "Cache settings",
+			Usage:    "max time while waiting requests will be waiting for coalesced upstream request",
+			Value:    10 * time.Second,
+		},
 		&cli.DurationFlag{
 			Name:     "cache-clean-window",
 			Category: "Cache settings",
@@ -304,16 +653,133 @@ func flagsInitialization(expertMode bool) []cli.Flag {
 			and public/random.php (www site), ensuring the high performance of these methods;
 			if disabled, all reuqests will be cached in shared cache pool with another methods`,
 		},
+		&cli.StringFlag{
+			Name:     "randomizer-source",
+			Category: "Release randomizer",
+			Usage: `source of releases; redis, file, http; redis - chunked releases export (randomizer-redis-*),
+			file - randomizer-source-file, http - paged crawl of randomizer-source-url`,
+			Value: "redis",
+		},
+		&cli.StringFlag{
+			Name:     "randomizer-source-file",
+			Category: "Release randomizer",
+			Usage: `path to releases file which is re-read on every update; .ndjson and .jsonl files
+			contain one release per line, other files contain JSON array or object of releases`,
+		},
+		&cli.StringFlag{
+			Name:     "randomizer-source-url",
+			Category: "Release randomizer",
+			Usage:    "apiv1 endpoint for the http source; proxy-dst-host is used as Host header",
+			Value:    "http://127.0.0.1:36080/public/api/index.php",
+		},
+		&cli.StringFlag{
+			Name:     "randomizer-source-args",
+			Category: "Release randomizer",
+			Usage:    "urlencoded args of the http source requests; page and perPage args are added by alice",
+			Value:    "query=list",
+			Hidden:   expertMode,
+		},
+		&cli.IntFlag{
+			Name:     "randomizer-source-per-page",
+			Category: "Release randomizer",
+			Value:    100,
+			Hidden:   expertMode,
+		},
+		&cli.IntFlag{
+			Name:     "randomizer-source-max-pages",
+			Category: "Release randomizer",
+			Usage:    "crawling is stopped after this number of pages",
+			Value:    1000,
+			Hidden:   expertMode,
+		},
+		&cli.DurationFlag{
+			Name:     "randomizer-source-timeout",
+			Category: "Release randomizer",
+			Usage:    "timeout of the one http source request",
+			Value:    10 * time.Second,
+			Hidden:   expertMode,
+		},
+		&cli.StringFlag{
+			Name:     "randomizer-redis-mode",
+			Category: "Release randomizer",
+			Usage:    "redis deployment; standalone, sentinel, cluster",
+			Value:    "standalone",
+		},
 		&cli.StringFlag{
 			Name:     "randomizer-redis-host",
 			Category: "Release randomizer",
-			Value:    "127.0.0.1:6279",
+			Usage: `redis address; comma-separated sentinel addresses in sentinel mode
+			and seed nodes in cluster mode`,
+			Value: "127.0.0.1:6279",
+		},
+		&cli.StringFlag{
+			Name:     "randomizer-redis-url",
+			Category: "Release randomizer",
+			Usage: `redis url which overrides host, credentials and database flags;
+			format - redis[s]://[user[:password]@]host[:port][/db]; rediss enables TLS;
+			cluster seed nodes could be added with addr params - redis://host1:6379?addr=host2:6379;
+			in sentinel mode url points to the sentinel and its credentials are the sentinel ones`,
+		},
+		&cli.StringFlag{
+			Name:     "randomizer-redis-username",
+			Category: "Release randomizer",
+			Usage:    "redis ACL username",
 		},
 		&cli.StringFlag{
 			Name:     "randomizer-redis-password",
 			Category: "Release randomizer",
 			Value:    "",
 		},
+		&cli.StringFlag{
+			Name:     "randomizer-redis-sentinel-master",
+			Category: "Release randomizer",
+			Usage:    "master name for sentinel master discovery",
+		},
+		&cli.StringFlag{
+			Name:     "randomizer-redis-sentinel-username",
+			Category: "Release randomizer",
+			Usage:    "sentinel ACL username",
+			Hidden:   expertMode,
+		},
+		&cli.StringFlag{
+			Name:     "randomizer-redis-sentinel-password",
+			Category: "Release randomizer",
+			Hidden:   expertMode,
+		},
+		&cli.BoolFlag{
+			Name:               "randomizer-redis-tls-enable",
+			Category:           "Release randomizer",
+			Usage:              "use TLS for redis connections; rediss url enables it too",
+			DisableDefaultText: true,
+		},
+		&cli.StringFlag{
+			Name:     "randomizer-redis-tls-ca",
+			Category: "Release randomizer",
+			Usage:    "path to PEM CA bundle for redis certificates verification; system CA is used if empty",
+		},
+		&cli.StringFlag{
+			Name:     "randomizer-redis-tls-cert",
+			Category: "Release randomizer",
+			Usage:    "path to PEM client certificate for redis mTLS",
+		},
+		&cli.StringFlag{
+			Name:     "randomizer-redis-tls-key",
+			Category: "Release randomizer",
+			Usage:    "path to PEM client certificate key for redis mTLS",
+		},
+		&cli.StringFlag{
+			Name:     "randomizer-redis-tls-server-name",
+			Category: "Release randomizer",
+			Usage:    "expected server name of redis certificates; the host name is used if empty",
+			Hidden:   expertMode,
+		},
+		&cli.BoolFlag{
+			Name:               "randomizer-redis-tls-insecure-skip-verify",
+			Category:           "Release randomizer",
+			Usage:              "do not verify redis certificates; INSECURE! for testing purposes only",
+			Hidden:             expertMode,
+			DisableDefaultText: true,
+		},
 		&cli.IntFlag{
 			Name:     "randomizer-redis-database",
 			Category: "Release randomizer",
@@ -331,6 +797,20 @@ func flagsInitialization(expertMode bool) []cli.Flag {
 			Value:    "apiInfo",
 			Hidden:   expertMode,
 		},
+		&cli.StringFlag{
+			Name:     "randomizer-release-fields",
+			Category: "Release randomizer",
+			Usage: `comma-separated top-level release fields for random_release responses;
+			the full release object is returned if empty; Example: id,code,names,posters`,
+		},
+		&cli.StringFlag{
+			Name:     "randomizer-ongoing-status",
+			Category: "Release randomizer",
+			Usage: `release status of ongoing releases for the ongoing filter of random_release;
+			random_release filters - genre, year, season, type, ongoing (1 or 0)`,
+			Value:  "В работе",
+			Hidden: expertMode,
+		},
 		&cli.DurationFlag{
 			Name:     "randomizer-update-frequency",
 			Category: "Release randomizer",
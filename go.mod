@@ -1,8 +1,9 @@
 module github.com/anilibria/alice
 
-go 1.19
+go 1.21
 
 require (
+	github.com/alicebob/miniredis/v2 v2.35.0
 	github.com/allegro/bigcache/v3 v3.1.0
 	github.com/gofiber/fiber/v2 v2.52.6
 	github.com/gofiber/storage/bbolt/v2 v2.0.0
@@ -34,6 +35,7 @@ require (
 	github.com/tinylib/msgp v1.2.5 // indirect
 	github.com/valyala/tcplisten v1.0.0 // indirect
 	github.com/xrash/smetrics v0.0.0-20240521201337-686a1a2994c1 // indirect
+	github.com/yuin/gopher-lua v1.1.1 // indirect
 	go.etcd.io/bbolt v1.3.9 // indirect
 	golang.org/x/sys v0.30.0 // indirect
 	golang.org/x/text v0.22.0 // indirect
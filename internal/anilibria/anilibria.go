@@ -1,11 +1,24 @@
 package anilibria
 
+import "encoding/json"
+
 type (
 	Releases map[string]*Release
 	Release  struct {
 		Id          uint
 		Code        string
 		BlockedInfo *ReleaseBlockedInfo `json:"blockedInfo"`
+
+		// attributes for random_release filters
+		Genres []releaseAttr
+		Year   releaseAttr
+		Season releaseAttr
+		Type   releaseAttr
+		Status releaseAttr
+		attrs  releaseAttrs
+
+		// Payload is the release object of the backend with selected fields only
+		Payload []byte `json:"-"`
 	}
 	ReleaseBlockedInfo struct {
 		Blocked               bool
@@ -14,3 +27,14 @@ type (
 		IsBlockedByCopyrights bool     `json:"is_blocked_by_copyrights"`
 	}
 )
+
+// UnmarshalJSON keeps the raw release object for random_release responses
+func (m *Release) UnmarshalJSON(data []byte) (e error) {
+	type release Release
+	if e = json.Unmarshal(data, (*release)(m)); e != nil {
+		return
+	}
+
+	m.Payload = append(m.Payload[:0], data...)
+	return
+}
@@ -0,0 +1,263 @@
+package anilibria
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	futils "github.com/gofiber/fiber/v2/utils"
+	"github.com/valyala/fasthttp"
+)
+
+var (
+	ErrNotReady  = errors.New("randomizer is not ready yet")
+	ErrNoMatches = errors.New("there are no releases matching the filter")
+)
+
+type OngoingFilter uint8
+
+const (
+	OFAny OngoingFilter = iota
+	OFOngoing
+	OFFinished
+)
+
+var Stoof = map[string]OngoingFilter{
+	"1": OFOngoing,
+	"0": OFFinished,
+}
+
+// ReleaseFilter contains random_release filter arguments; empty values match any release
+type ReleaseFilter struct {
+	Genre  string
+	Year   int
+	Season string
+	Type   string
+
+	Ongoing OngoingFilter
+}
+
+// NewReleaseFilter parses genre, year, season, type and ongoing (1 or 0) request args
+func NewReleaseFilter(args *fasthttp.Args) (_ *ReleaseFilter, e error) {
+	filter := &ReleaseFilter{
+		Genre:  normalizeAttr(args.Peek("genre")),
+		Season: normalizeAttr(args.Peek("season")),
+		Type:   normalizeType(args.Peek("type")),
+	}
+
+	if year := args.Peek("year"); len(year) != 0 {
+		if filter.Year, e = strconv.Atoi(futils.UnsafeString(year)); e != nil || filter.Year <= 0 {
+			return nil, errors.New("random release filter year must be a positive integer")
+		}
+	}
+
+	if ongoing := args.Peek("ongoing"); len(ongoing) != 0 {
+		var ok bool
+		if filter.Ongoing, ok = Stoof[futils.UnsafeString(ongoing)]; !ok {
+			return nil, errors.New("random release filter ongoing must be 1 or 0")
+		}
+	}
+
+	return filter, nil
+}
+
+func (m *ReleaseFilter) IsEmpty() bool {
+	return m == nil || *m == ReleaseFilter{}
+}
+
+// key is used for memoization of filtered buckets
+func (m *ReleaseFilter) key() string {
+	return fmt.Sprintf("%s|%d|%s|%s|%d", m.Genre, m.Year, m.Season, m.Type, m.Ongoing)
+}
+
+// indexes returns keys of the precomputed attribute buckets for the filter
+func (m *ReleaseFilter) indexes() (keys []string) {
+	if m.Genre != "" {
+		keys = append(keys, "genre="+m.Genre)
+	}
+
+	if m.Year != 0 {
+		keys = append(keys, "year="+strconv.Itoa(m.Year))
+	}
+
+	if m.Season != "" {
+		keys = append(keys, "season="+m.Season)
+	}
+
+	if m.Type != "" {
+		keys = append(keys, "type="+m.Type)
+	}
+
+	if m.Ongoing != OFAny {
+		keys = append(keys, "ongoing="+strconv.FormatBool(m.Ongoing == OFOngoing))
+	}
+
+	return
+}
+
+func (m *ReleaseFilter) match(release *Release) bool {
+	attrs := &release.attrs
+
+	switch {
+	case m.Year != 0 && attrs.year != m.Year:
+		return false
+	case m.Season != "" && attrs.season != m.Season:
+		return false
+	case m.Type != "" && attrs.rtype != m.Type:
+		return false
+	case m.Ongoing != OFAny && attrs.ongoing != (m.Ongoing == OFOngoing):
+		return false
+	case m.Genre == "":
+		return true
+	}
+
+	for _, genre := range attrs.genres {
+		if genre == m.Genre {
+			return true
+		}
+	}
+
+	return false
+}
+
+//
+
+// releaseAttr accepts both strings and numbers, other values are ignored,
+// so unexpected attribute formats do not break release parsing
+type releaseAttr string
+
+func (m *releaseAttr) UnmarshalJSON(data []byte) (e error) {
+	switch {
+	case len(data) == 0:
+		return
+	case data[0] == '"':
+		var value string
+		if e = json.Unmarshal(data, &value); e != nil {
+			return
+		}
+
+		*m = releaseAttr(value)
+	case data[0] == '-' || (data[0] >= '0' && data[0] <= '9'):
+		*m = releaseAttr(data)
+	}
+
+	return
+}
+
+// releaseAttrs are normalized release attributes for filtering
+type releaseAttrs struct {
+	genres  []string
+	year    int
+	season  string
+	rtype   string
+	ongoing bool
+}
+
+func (m *Release) index(ongoingStatus string) {
+	m.attrs.genres = make([]string, 0, len(m.Genres))
+	for _, genre := range m.Genres {
+		if genre := normalizeAttr([]byte(genre)); genre != "" {
+			m.attrs.genres = append(m.attrs.genres, genre)
+		}
+	}
+
+	m.attrs.year, _ = strconv.Atoi(strings.TrimSpace(string(m.Year)))
+	m.attrs.season = normalizeAttr([]byte(m.Season))
+	m.attrs.rtype = normalizeType([]byte(m.Type))
+	m.attrs.ongoing = ongoingStatus != "" && normalizeAttr([]byte(m.Status)) == ongoingStatus
+}
+
+func (m *Release) indexKeys() (keys []string) {
+	for _, genre := range m.attrs.genres {
+		keys = append(keys, "genre="+genre)
+	}
+
+	if m.attrs.year != 0 {
+		keys = append(keys, "year="+strconv.Itoa(m.attrs.year))
+	}
+
+	if m.attrs.season != "" {
+		keys = append(keys, "season="+m.attrs.season)
+	}
+
+	if m.attrs.rtype != "" {
+		keys = append(keys, "type="+m.attrs.rtype)
+	}
+
+	return append(keys, "ongoing="+strconv.FormatBool(m.attrs.ongoing))
+}
+
+func normalizeAttr(raw []byte) string {
+	return strings.ToLower(string(bytes.TrimSpace(raw)))
+}
+
+// release type is stored with its details, e.g. "ТВ (>12 эп.), 25 мин."; only the kind is indexed
+func normalizeType(raw []byte) string {
+	if idx := bytes.IndexAny(raw, "(,"); idx != -1 {
+		raw = raw[:idx]
+	}
+
+	return normalizeAttr(raw)
+}
+
+//
+
+// maximum number of memoized filtered buckets; all of them are dropped on overflow
+const maxFilteredBuckets = 4096
+
+// filteredBuckets memoizes buckets of filtered releases by country and filter
+type filteredBuckets struct {
+	mu      sync.RWMutex
+	buckets map[string][]*Release
+}
+
+func (m *releaseBuckets) filtered(country string, filter *ReleaseFilter) []*Release {
+	key := country + "|" + filter.key()
+
+	m.memo.mu.RLock()
+	bucket, ok := m.memo.buckets[key]
+	m.memo.mu.RUnlock()
+
+	if ok {
+		return bucket
+	}
+
+	// bucket is built outside of the lock; concurrent misses of the same key
+	// build equal buckets, so the last one just replaces the others
+	bucket = m.buildFiltered(country, filter)
+
+	m.memo.mu.Lock()
+	defer m.memo.mu.Unlock()
+
+	if m.memo.buckets == nil || len(m.memo.buckets) >= maxFilteredBuckets {
+		m.memo.buckets = make(map[string][]*Release)
+	}
+
+	m.memo.buckets[key] = bucket
+	return bucket
+}
+
+func (m *releaseBuckets) buildFiltered(country string, filter *ReleaseFilter) []*Release {
+	// the smallest precomputed bucket is narrowed by the rest of the filter
+	candidates := m.country(country)
+	for _, index := range filter.indexes() {
+		if len(m.attrs[index]) < len(candidates) {
+			candidates = m.attrs[index]
+		}
+	}
+
+	excluded := m.excluded[country]
+	bucket := make([]*Release, 0)
+
+	for _, release := range candidates {
+		if !excluded[release] && filter.match(release) {
+			bucket = append(bucket, release)
+		}
+	}
+
+	return bucket
+}
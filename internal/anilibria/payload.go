@@ -0,0 +1,74 @@
+package anilibria
+
+import (
+	"strings"
+
+	"github.com/mailru/easyjson/jlexer"
+	"github.com/mailru/easyjson/jwriter"
+)
+
+// releaseFields is a set of top-level release fields for random_release responses;
+// nil set keeps the full release object
+type releaseFields map[string]bool
+
+// randomizer-release-fields format - id,code,names,posters
+func newReleaseFields(raw string) releaseFields {
+	var fields releaseFields
+
+	for _, field := range strings.Split(raw, ",") {
+		if field = strings.TrimSpace(field); field == "" {
+			continue
+		}
+
+		if fields == nil {
+			fields = make(releaseFields)
+		}
+		fields[field] = true
+	}
+
+	return fields
+}
+
+// payload returns the release object with selected fields only; field values are copied as is
+func (m releaseFields) payload(raw []byte) (_ []byte, e error) {
+	if m == nil {
+		return raw, e
+	}
+
+	in := jlexer.Lexer{Data: raw}
+	out := jwriter.Writer{}
+
+	out.RawByte('{')
+	first := true
+
+	in.Delim('{')
+	for !in.IsDelim('}') {
+		key := in.UnsafeFieldName(false)
+		in.WantColon()
+		value := in.Raw()
+		in.WantComma()
+
+		if !m[key] {
+			continue
+		}
+
+		if !first {
+			out.RawByte(',')
+		}
+		first = false
+
+		out.String(key)
+		out.RawByte(':')
+		out.Raw(value, nil)
+	}
+	in.Delim('}')
+	in.Consumed()
+
+	out.RawByte('}')
+
+	if e = in.Error(); e != nil {
+		return
+	}
+
+	return out.BuildBytes()
+}
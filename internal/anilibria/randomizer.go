@@ -2,18 +2,13 @@ package anilibria
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
-	"fmt"
 	"math/rand"
-	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/anilibria/alice/internal/utils"
-	futils "github.com/gofiber/fiber/v2/utils"
-	"github.com/klauspost/compress/zstd"
-	"github.com/redis/go-redis/v9"
 	"github.com/rs/zerolog"
 	"github.com/urfave/cli/v2"
 )
@@ -23,58 +18,67 @@ type Randomizer struct {
 	done  func() <-chan struct{}
 	abort context.CancelFunc
 
-	rctx    context.Context
-	rclient *redis.Client
+	source ReleaseSource
 
-	releasesKey    string
 	relUpdFreq     time.Duration
 	relUpdFreqErr  time.Duration
 	relUpdFreqBoot time.Duration
 
-	decoder *zstd.Decoder
+	fields releaseFields
+
+	// lowercased release status of ongoing releases
+	ongoingStatus string
 
 	mu       sync.RWMutex
-	releases []string
+	releases *releaseBuckets
+}
+
+// releaseBuckets contains all available releases, per-country buckets
+// without releases which are geo-blocked in the country and per-attribute buckets
+type releaseBuckets struct {
+	all      []*Release
+	geo      map[string][]*Release
+	excluded map[string]map[*Release]bool
+
+	// attribute buckets by keys like genre=драма, year=2020, ongoing=true
+	attrs map[string][]*Release
+	memo  filteredBuckets
+}
+
+func (m *releaseBuckets) country(country string) []*Release {
+	if bucket, ok := m.geo[country]; ok {
+		return bucket
+	}
+
+	return m.all
 }
 
-func New(c context.Context) *Randomizer {
+func New(c context.Context) (_ *Randomizer, e error) {
 	cli := c.Value(utils.CKCliCtx).(*cli.Context)
+	log := c.Value(utils.CKLogger).(*zerolog.Logger)
 
-	var dec *zstd.Decoder
-	if cli.Bool("randomizer-redis-zstd-enable") {
-		dec, _ = zstd.NewReader(nil, zstd.WithDecoderConcurrency(0))
+	var source ReleaseSource
+	if source, e = newReleaseSource(cli, log); e != nil {
+		return
 	}
 
-	r := &Randomizer{
+	return &Randomizer{
 		done:  c.Done,
-		log:   c.Value(utils.CKLogger).(*zerolog.Logger),
+		log:   log,
 		abort: c.Value(utils.CKAbortFunc).(context.CancelFunc),
 
-		rctx: context.Background(),
-		rclient: redis.NewClient(&redis.Options{
-			Addr:     cli.String("randomizer-redis-host"),
-			Password: cli.String("randomizer-redis-password"),
-			DB:       cli.Int("randomizer-redis-database"),
-
-			ClientName: fmt.Sprintf("%s/%s", cli.App.Name, cli.App.Version),
-
-			MaxRetries:   cli.Int("redis-client-maxretries"),
-			DialTimeout:  cli.Duration("redis-client-dialtimeout"),
-			ReadTimeout:  cli.Duration("redis-client-readtimeout"),
-			WriteTimeout: cli.Duration("redis-client-writetimeout"),
-		}),
+		source: source,
 
 		relUpdFreq:     cli.Duration("randomizer-update-frequency"),
 		relUpdFreqErr:  cli.Duration("randomizer-update-frequency-onerror"),
 		relUpdFreqBoot: cli.Duration("randomizer-update-frequency-bootstrap"),
 
-		decoder: dec,
+		fields: newReleaseFields(cli.String("randomizer-release-fields")),
 
-		releases:    make([]string, 0),
-		releasesKey: cli.String("randomizer-releaseskey"),
-	}
+		ongoingStatus: normalizeAttr([]byte(cli.String("randomizer-ongoing-status"))),
 
-	return r
+		releases: &releaseBuckets{},
+	}, e
 }
 
 func (m *Randomizer) Bootstrap() {
@@ -82,8 +86,10 @@ func (m *Randomizer) Bootstrap() {
 	m.destroy()
 }
 
-func (m *Randomizer) Randomize() string {
-	return m.randomRelease()
+// Randomize returns a random release which is viewable from the country (ISO code) and matches
+// the filter; releases are not filtered by geo blocks if the country is unknown
+func (m *Randomizer) Randomize(country string, filter *ReleaseFilter) (*Release, error) {
+	return m.randomRelease(strings.ToUpper(country), filter)
 }
 
 //
@@ -104,7 +110,7 @@ LOOP:
 			update.Stop()
 
 			var e error
-			var releases []string
+			var releases *releaseBuckets
 			if releases, e = m.lookupReleases(); e != nil {
 				m.log.Error().Msg("could not updated releases for randomizer - " + e.Error())
 				update.Reset(m.relUpdFreqErr)
@@ -118,90 +124,21 @@ LOOP:
 }
 
 func (m *Randomizer) destroy() {
-	if e := m.rclient.Close(); e != nil {
-		m.log.Error().Msg("could not properly close http client - " + e.Error())
-	}
-}
-
-func (m *Randomizer) peekReleaseKeyChunks() (_ int, e error) {
-	var res string
-	if res, e = m.rclient.Get(m.rctx, m.releasesKey).Result(); e == redis.Nil {
-		e = errors.New("no such release key in redis; is it correct - " + m.releasesKey)
-		return
-	} else if e != nil {
-		return
-	} else if res == "" {
-		e = errors.New("redis client respond with an empty string; is release key is alive?")
-		return
-	}
-
-	var dres []byte
-	if dres, e = m.decompressPayload(futils.UnsafeBytes(res)); e != nil {
-		m.log.Warn().Msg("an error occurred while decompress response redis response - " + e.Error())
-		return
+	if e := m.source.Close(); e != nil {
+		m.log.Error().Msg("could not properly close release source - " + e.Error())
 	}
-
-	return strconv.Atoi(futils.UnsafeString(dres))
 }
 
-func (m *Randomizer) lookupReleases() (_ []string, e error) { // skipcq: GO-R1005 needed to be kept as it is
-	var chunks int
-	if chunks, e = m.peekReleaseKeyChunks(); e != nil {
-		return
-	} else if chunks == 0 {
-		e = errors.New("invalid chunks count was responded by redis client or converted by golang")
-		return
-	}
-	m.log.Trace().Msgf("release key says about %d chunks", chunks)
-	m.log.Info().Msgf("staring release parsing from redis with %d chunks", chunks)
-
+func (m *Randomizer) lookupReleases() (_ *releaseBuckets, e error) {
 	// avoid mass allocs
 	started := time.Now()
-	releases := make([]string, 0, len(m.releases))
+	releases := make([]*Release, 0, m.releasesLen())
+	exclusions := make(map[string]map[*Release]bool)
 
-	var res string
-	var errs []string
 	var total, banned int
 
-	for i := 0; i < chunks; i++ {
-		select {
-		case <-m.done():
-			e = errors.New("chunk parsing has been interrupted by global abort()")
-			return
-		default:
-			m.log.Trace().Msgf("parsing chunk %d/%d...", i, chunks)
-		}
-
-		// get compressed chunk response from redis
-		if res, e = m.rclient.Get(m.rctx, m.releasesKey+strconv.Itoa(i)).Result(); e == redis.Nil {
-			e = fmt.Errorf("given chunk number %d is not exists", i)
-			m.log.Warn().Msg(e.Error())
-			errs = append(errs, e.Error())
-			continue
-		} else if e != nil {
-			m.log.Warn().Msg("an error occurred while peeking a releases chunk - " + e.Error())
-			errs = append(errs, e.Error())
-			continue
-		}
-
-		// decompress chunk response from redis
-		var dres []byte
-		if dres, e = m.decompressPayload(futils.UnsafeBytes(res)); e != nil {
-			m.log.Warn().Msg("an error occurred while decompress redis response - " + e.Error())
-			errs = append(errs, e.Error())
-			continue
-		}
-
-		// get json formated response from decompressed response
-		var releasesChunk Releases
-		if e = json.Unmarshal(dres, &releasesChunk); e != nil {
-			m.log.Warn().Msg("an error occurred while unmarshal release chunk - " + e.Error())
-			errs = append(errs, e.Error())
-			continue
-		}
-
-		// parse json chunk response
-		for _, release := range releasesChunk {
+	e = m.source.Fetch(m.done, func(batch Releases) {
+		for _, release := range batch {
 			if release.BlockedInfo != nil && release.BlockedInfo.IsBlockedByCopyrights {
 				m.log.Debug().Msgf("release %d (%s) worldwide banned, skip it...", release.Id, release.Code)
 				banned++
@@ -212,55 +149,111 @@ func (m *Randomizer) lookupReleases() (_ []string, e error) { // skipcq: GO-R100
 				m.log.Trace().Msgf("release %d with code %s found", release.Id, release.Code)
 			}
 
+			var err error
+			if release.Payload, err = m.fields.payload(release.Payload); err != nil {
+				m.log.Warn().Msgf("could not select fields of release %d (%s) - %s", release.Id, release.Code, err.Error())
+				continue
+			}
+
+			release.index(m.ongoingStatus)
+
 			total++
-			releases = append(releases, release.Code)
+			releases = append(releases, release)
+
+			if release.BlockedInfo == nil {
+				continue
+			}
+
+			for _, country := range release.BlockedInfo.IsBlockedInGeo {
+				if country = strings.ToUpper(strings.TrimSpace(country)); country == "" {
+					continue
+				}
+
+				if exclusions[country] == nil {
+					exclusions[country] = make(map[*Release]bool)
+				}
+				exclusions[country][release] = true
+			}
 		}
 
+	})
+
+	if e != nil {
+		return
+	} else if len(releases) == 0 {
+		// truncated or corrupted source must not replace the current releases
+		e = errors.New("there are no releases in the randomizer source")
+		return
+	}
+
+	buckets := &releaseBuckets{
+		all:      releases,
+		geo:      make(map[string][]*Release, len(exclusions)),
+		excluded: exclusions,
+		attrs:    make(map[string][]*Release),
 	}
 
-	if errslen := len(errs); errslen != 0 {
-		m.log.Error().Msgf("%d chunks were corrupted, data from them did not get into the cache", errslen)
-		m.log.Error().Msg("release redis extraction process errors:")
+	for _, release := range releases {
+		for _, key := range release.indexKeys() {
+			buckets.attrs[key] = append(buckets.attrs[key], release)
+		}
+	}
 
-		for _, err := range errs {
-			m.log.Error().Msg(err)
+	for country, excluded := range exclusions {
+		bucket := make([]*Release, 0, len(releases)-len(excluded))
+		for _, release := range releases {
+			if !excluded[release] {
+				bucket = append(bucket, release)
+			}
 		}
+
+		m.log.Debug().Msgf("country %s has %d geo-blocked releases", country, len(excluded))
+		buckets.geo[country] = bucket
 	}
 
-	m.log.Info().Msgf("in %s from %d (of %d) chunks added %d releases and %d skipped because of WW ban",
-		time.Since(started).String(), chunks-len(errs), chunks, total, banned)
-	return releases, nil
+	m.log.Info().Msgf("in %s added %d releases and %d skipped because of WW ban; %d countries have geo blocks; %d attribute buckets",
+		time.Since(started).String(), total, banned, len(buckets.geo), len(buckets.attrs))
+	return buckets, nil
 }
 
-func (m *Randomizer) rotateReleases(releases []string) {
+func (m *Randomizer) rotateReleases(releases *releaseBuckets) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
 	m.log.Debug().Msgf("update current %d releases with slice of %d releases",
-		len(m.releases), len(releases))
+		len(m.releases.all), len(releases.all))
 	m.releases = releases
 }
 
-func (m *Randomizer) randomRelease() (_ string) {
+func (m *Randomizer) releasesLen() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return len(m.releases.all)
+}
+
+func (m *Randomizer) randomRelease(country string, filter *ReleaseFilter) (_ *Release, e error) {
 	if !m.mu.TryRLock() {
 		m.log.Warn().Msg("could not get randomized release, read lock is not available")
-		return
+		return nil, ErrNotReady
 	}
 	defer m.mu.RUnlock()
 
-	if len(m.releases) == 0 {
+	if len(m.releases.all) == 0 {
 		m.log.Warn().Msg("randomizer is not ready yet")
-		return
+		return nil, ErrNotReady
 	}
 
-	r := rand.Intn(len(m.releases)) // skipcq: GSC-G404 math/rand is enoght here
-	return m.releases[r]
-}
+	releases := m.releases.country(country)
+	if !filter.IsEmpty() {
+		releases = m.releases.filtered(country, filter)
+	}
 
-func (m *Randomizer) decompressPayload(payload []byte) ([]byte, error) {
-	if m.decoder == nil {
-		return payload, nil
+	if len(releases) == 0 {
+		m.log.Debug().Msg("there are no releases available for country " + country + " and the filter")
+		return nil, ErrNoMatches
 	}
 
-	return m.decoder.DecodeAll(payload, nil)
+	r := rand.Intn(len(releases)) // skipcq: GSC-G404 math/rand is enoght here
+	return releases[r], e
 }
@@ -0,0 +1,199 @@
+package anilibria
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/urfave/cli/v2"
+)
+
+type RedisMode uint8
+
+const (
+	RMStandalone RedisMode = iota
+	RMSentinel
+	RMCluster
+)
+
+var Storm = map[string]RedisMode{
+	"standalone": RMStandalone,
+	"sentinel":   RMSentinel,
+	"cluster":    RMCluster,
+}
+
+// newRedisClient builds the randomizer redis client; randomizer-redis-host contains
+// sentinel addresses in sentinel mode and seed nodes in cluster mode
+func newRedisClient(c *cli.Context) (_ redis.UniversalClient, e error) {
+	mode, ok := Storm[c.String("randomizer-redis-mode")]
+	if !ok {
+		e = fmt.Errorf("unknown redis mode %s", c.String("randomizer-redis-mode"))
+		return
+	}
+
+	opts := &redis.UniversalOptions{
+		Addrs:    splitRedisAddrs(c.String("randomizer-redis-host")),
+		Username: c.String("randomizer-redis-username"),
+		Password: c.String("randomizer-redis-password"),
+		DB:       c.Int("randomizer-redis-database"),
+
+		MasterName:       c.String("randomizer-redis-sentinel-master"),
+		SentinelUsername: c.String("randomizer-redis-sentinel-username"),
+		SentinelPassword: c.String("randomizer-redis-sentinel-password"),
+
+		ClientName: fmt.Sprintf("%s/%s", c.App.Name, c.App.Version),
+
+		MaxRetries:   c.Int("redis-client-maxretries"),
+		DialTimeout:  c.Duration("redis-client-dialtimeout"),
+		ReadTimeout:  c.Duration("redis-client-readtimeout"),
+		WriteTimeout: c.Duration("redis-client-writetimeout"),
+	}
+
+	if rawurl := c.String("randomizer-redis-url"); rawurl != "" {
+		if e = applyRedisURL(opts, mode, rawurl); e != nil {
+			return
+		}
+	}
+
+	if opts.TLSConfig, e = newRedisTLSConfig(c, opts.TLSConfig); e != nil {
+		return
+	}
+
+	switch {
+	case len(opts.Addrs) == 0:
+		e = errors.New("randomizer-redis-host or randomizer-redis-url must be defined")
+	case mode == RMSentinel && opts.MasterName == "":
+		e = errors.New("randomizer-redis-sentinel-master must be defined in sentinel mode")
+	case mode == RMCluster && opts.DB != 0:
+		e = errors.New("redis cluster does not support databases, randomizer-redis-database must be 0")
+	case mode == RMStandalone && len(opts.Addrs) != 1:
+		e = errors.New("only one redis address could be defined in standalone mode")
+	}
+
+	if e != nil {
+		return
+	}
+
+	switch mode {
+	case RMSentinel:
+		return redis.NewFailoverClient(opts.Failover()), e
+	case RMCluster:
+		return redis.NewClusterClient(opts.Cluster()), e
+	default:
+		return redis.NewClient(opts.Simple()), e
+	}
+}
+
+// applyRedisURL overrides addresses, credentials, database and tls with the url values;
+// format - redis[s]://[user[:password]@]host[:port][/db]; cluster seed nodes could be
+// added with addr params - rediss://host1:6379?addr=host2:6379
+func applyRedisURL(opts *redis.UniversalOptions, mode RedisMode, rawurl string) (e error) {
+	var u *url.URL
+	if u, e = url.Parse(rawurl); e != nil {
+		return
+	} else if u.Scheme != "redis" && u.Scheme != "rediss" {
+		return fmt.Errorf("unsupported redis url scheme %s, redis and rediss are supported", u.Scheme)
+	}
+
+	if mode == RMCluster {
+		// database is silently ignored by the cluster url parser
+		if path := strings.Trim(u.Path, "/"); path != "" && path != "0" {
+			return errors.New("redis cluster does not support databases, url must not contain the database")
+		}
+
+		var copts *redis.ClusterOptions
+		if copts, e = redis.ParseClusterURL(rawurl); e != nil {
+			return
+		}
+
+		opts.Addrs, opts.Username, opts.Password, opts.TLSConfig =
+			copts.Addrs, copts.Username, copts.Password, copts.TLSConfig
+	} else {
+		var sopts *redis.Options
+		if sopts, e = redis.ParseURL(rawurl); e != nil {
+			return
+		}
+
+		opts.Addrs, opts.DB, opts.TLSConfig = []string{sopts.Addr}, sopts.DB, sopts.TLSConfig
+
+		// url host is the sentinel in sentinel mode, so are the credentials;
+		// master credentials are taken from randomizer-redis-username and randomizer-redis-password
+		if mode == RMSentinel {
+			opts.SentinelUsername, opts.SentinelPassword = sopts.Username, sopts.Password
+		} else {
+			opts.Username, opts.Password = sopts.Username, sopts.Password
+		}
+	}
+
+	// tls server name is pinned to the url host, but discovered masters and cluster nodes
+	// have their own names; empty name is taken from the address of every connection
+	if mode != RMStandalone && opts.TLSConfig != nil {
+		opts.TLSConfig.ServerName = ""
+	}
+
+	return
+}
+
+// newRedisTLSConfig returns nil if tls is not enabled by flags or by rediss url
+func newRedisTLSConfig(c *cli.Context, base *tls.Config) (_ *tls.Config, e error) {
+	if !c.Bool("randomizer-redis-tls-enable") && base == nil {
+		return
+	}
+
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if base != nil {
+		config = base.Clone()
+		config.MinVersion = tls.VersionTLS12
+	}
+
+	if name := c.String("randomizer-redis-tls-server-name"); name != "" {
+		config.ServerName = name
+	}
+
+	config.InsecureSkipVerify = c.Bool("randomizer-redis-tls-insecure-skip-verify") // skipcq: GSC-G402 it's an explicit option
+
+	if path := c.String("randomizer-redis-tls-ca"); path != "" {
+		var pem []byte
+		if pem, e = os.ReadFile(path); e != nil {
+			return
+		}
+
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			e = errors.New("could not find any certificate in " + path)
+			return
+		}
+	}
+
+	certPath, keyPath := c.String("randomizer-redis-tls-cert"), c.String("randomizer-redis-tls-key")
+	if (certPath == "") != (keyPath == "") {
+		e = errors.New("both randomizer-redis-tls-cert and randomizer-redis-tls-key must be defined for mTLS")
+		return
+	}
+
+	if certPath != "" {
+		var cert tls.Certificate
+		if cert, e = tls.LoadX509KeyPair(certPath, keyPath); e != nil {
+			return
+		}
+
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config, e
+}
+
+func splitRedisAddrs(raw string) (addrs []string) {
+	for _, addr := range strings.Split(raw, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			addrs = append(addrs, addr)
+		}
+	}
+
+	return
+}
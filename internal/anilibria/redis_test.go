@@ -0,0 +1,598 @@
+package anilibria
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/urfave/cli/v2"
+)
+
+// newTestContext returns cli context with the given flags; redis mode is standalone by default
+func newTestContext(t *testing.T, flags map[string]string) *cli.Context {
+	t.Helper()
+
+	set := flag.NewFlagSet("alice", flag.ContinueOnError)
+	set.String("randomizer-redis-mode", "standalone", "")
+	set.String("redis-client-dialtimeout", "1s", "")
+	set.String("redis-client-readtimeout", "1s", "")
+	set.String("redis-client-writetimeout", "1s", "")
+
+	for name, value := range flags {
+		if set.Lookup(name) != nil {
+			if e := set.Set(name, value); e != nil {
+				t.Fatal(e)
+			}
+			continue
+		}
+
+		set.String(name, value, "")
+	}
+
+	return cli.NewContext(&cli.App{Name: "alice", Version: "test"}, set, nil)
+}
+
+func pingTestRedis(t *testing.T, c *cli.Context) error {
+	t.Helper()
+
+	client, e := newRedisClient(c)
+	if e != nil {
+		t.Fatalf("could not create redis client - %v", e)
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	return client.Ping(ctx).Err()
+}
+
+func TestRedisClientStandalone(t *testing.T) {
+	srv := miniredis.RunT(t)
+	srv.Set("releases", "1")
+
+	client, e := newRedisClient(newTestContext(t, map[string]string{
+		"randomizer-redis-host": srv.Addr(),
+	}))
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer client.Close()
+
+	if _, ok := client.(*redis.Client); !ok {
+		t.Fatalf("standalone mode must use the simple client, got %T", client)
+	}
+
+	if res, e := client.Get(context.Background(), "releases").Result(); e != nil || res != "1" {
+		t.Fatalf("unexpected releases key value %q - %v", res, e)
+	}
+}
+
+func TestRedisClientACL(t *testing.T) {
+	srv := miniredis.RunT(t)
+	srv.RequireUserAuth("alice", "secret")
+
+	if e := pingTestRedis(t, newTestContext(t, map[string]string{
+		"randomizer-redis-host":     srv.Addr(),
+		"randomizer-redis-username": "alice",
+		"randomizer-redis-password": "secret",
+	})); e != nil {
+		t.Fatalf("ACL user must be authenticated - %v", e)
+	}
+
+	if e := pingTestRedis(t, newTestContext(t, map[string]string{
+		"randomizer-redis-host":     srv.Addr(),
+		"randomizer-redis-username": "alice",
+		"randomizer-redis-password": "wrong",
+	})); e == nil {
+		t.Fatal("ACL user with the wrong password must be rejected")
+	}
+
+	if e := pingTestRedis(t, newTestContext(t, map[string]string{
+		"randomizer-redis-url": "redis://alice:secret@" + srv.Addr() + "/0",
+	})); e != nil {
+		t.Fatalf("ACL user from the url must be authenticated - %v", e)
+	}
+}
+
+func TestRedisURL(t *testing.T) {
+	tests := []struct {
+		name, url  string
+		mode       RedisMode
+		addrs      []string
+		username   string
+		password   string
+		sentinel   string
+		db         int
+		tls        bool
+		serverName string
+		err        bool
+	}{
+		{name: "redis", url: "redis://user:pw@10.0.0.1:6380/2", mode: RMStandalone,
+			addrs: []string{"10.0.0.1:6380"}, username: "user", password: "pw", db: 2},
+		{name: "rediss", url: "rediss://redis.example.org", mode: RMStandalone,
+			addrs: []string{"redis.example.org:6379"}, tls: true, serverName: "redis.example.org"},
+		{name: "sentinel rediss", url: "rediss://:pw@sentinel.example.org:26379", mode: RMSentinel,
+			addrs: []string{"sentinel.example.org:26379"}, sentinel: "pw", tls: true},
+		{name: "cluster rediss", url: "rediss://node1.example.org:6379?addr=node2.example.org:6379", mode: RMCluster,
+			addrs: []string{"node1.example.org:6379", "node2.example.org:6379"}, tls: true},
+		{name: "unsupported scheme", url: "unix:///run/redis.sock", mode: RMStandalone, err: true},
+		{name: "invalid db", url: "redis://10.0.0.1:6379/db", mode: RMStandalone, err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := &redis.UniversalOptions{}
+
+			e := applyRedisURL(opts, tt.mode, tt.url)
+			if tt.err {
+				if e == nil {
+					t.Fatal("url must be rejected")
+				}
+				return
+			} else if e != nil {
+				t.Fatal(e)
+			}
+
+			if strings.Join(opts.Addrs, ",") != strings.Join(tt.addrs, ",") {
+				t.Errorf("addrs %v, expected %v", opts.Addrs, tt.addrs)
+			}
+
+			if opts.Username != tt.username || opts.Password != tt.password || opts.DB != tt.db {
+				t.Errorf("credentials %s:%s db %d, expected %s:%s db %d",
+					opts.Username, opts.Password, opts.DB, tt.username, tt.password, tt.db)
+			} else if opts.SentinelPassword != tt.sentinel {
+				t.Errorf("sentinel password %q, expected %q", opts.SentinelPassword, tt.sentinel)
+			}
+
+			if (opts.TLSConfig != nil) != tt.tls {
+				t.Fatalf("tls config %v, expected tls %t", opts.TLSConfig, tt.tls)
+			} else if tt.tls && opts.TLSConfig.ServerName != tt.serverName {
+				t.Errorf("tls server name %q, expected %q", opts.TLSConfig.ServerName, tt.serverName)
+			}
+		})
+	}
+}
+
+func TestRedisTLSServerName(t *testing.T) {
+	client, e := newRedisClient(newTestContext(t, map[string]string{
+		"randomizer-redis-mode":            "sentinel",
+		"randomizer-redis-url":             "rediss://sentinel.example.org:26379",
+		"randomizer-redis-sentinel-master": "mymaster",
+		"randomizer-redis-tls-server-name": "redis.example.org",
+	}))
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer client.Close()
+
+	config, e := newRedisTLSConfig(newTestContext(t, map[string]string{
+		"randomizer-redis-tls-server-name": "redis.example.org",
+	}), &tls.Config{})
+	if e != nil {
+		t.Fatal(e)
+	} else if config.ServerName != "redis.example.org" {
+		t.Fatalf("tls server name %q must be taken from randomizer-redis-tls-server-name", config.ServerName)
+	}
+}
+
+func TestRedisClientTLS(t *testing.T) {
+	ca, caKey, caPEM := newTestRedisCA(t)
+	srvCert, _, _ := issueTestRedisCert(t, ca, caKey, x509.ExtKeyUsageServerAuth, net.ParseIP("127.0.0.1"))
+	_, cliCert, cliKey := issueTestRedisCert(t, ca, caKey, x509.ExtKeyUsageClientAuth)
+
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca)
+
+	srv, e := miniredis.RunTLS(&tls.Config{
+		Certificates: []tls.Certificate{srvCert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientCAs,
+	})
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer srv.Close()
+
+	dir := t.TempDir()
+	caPath := writeTestRedisFile(t, dir, "ca.pem", caPEM)
+	certPath := writeTestRedisFile(t, dir, "cert.pem", cliCert)
+	keyPath := writeTestRedisFile(t, dir, "key.pem", cliKey)
+
+	if e = pingTestRedis(t, newTestContext(t, map[string]string{
+		"randomizer-redis-url":      "rediss://" + srv.Addr(),
+		"randomizer-redis-tls-ca":   caPath,
+		"randomizer-redis-tls-cert": certPath,
+		"randomizer-redis-tls-key":  keyPath,
+	})); e != nil {
+		t.Fatalf("mTLS connection with the custom CA must be established - %v", e)
+	}
+
+	if e = pingTestRedis(t, newTestContext(t, map[string]string{
+		"randomizer-redis-host":       srv.Addr(),
+		"randomizer-redis-tls-enable": "true",
+		"randomizer-redis-tls-ca":     caPath,
+	})); e == nil {
+		t.Fatal("connection without client certificate must be rejected")
+	}
+
+	if e = pingTestRedis(t, newTestContext(t, map[string]string{
+		"randomizer-redis-host":       srv.Addr(),
+		"randomizer-redis-tls-enable": "true",
+		"randomizer-redis-tls-cert":   certPath,
+		"randomizer-redis-tls-key":    keyPath,
+	})); e == nil {
+		t.Fatal("server certificate signed by the unknown CA must be rejected")
+	}
+}
+
+func TestRedisClientOptionErrors(t *testing.T) {
+	dir := t.TempDir()
+
+	tests := []struct {
+		name  string
+		flags map[string]string
+	}{
+		{name: "unknown mode", flags: map[string]string{
+			"randomizer-redis-mode": "replication",
+			"randomizer-redis-host": "127.0.0.1:6379",
+		}},
+		{name: "no addresses", flags: map[string]string{}},
+		{name: "standalone with many addresses", flags: map[string]string{
+			"randomizer-redis-host": "127.0.0.1:6379,127.0.0.2:6379",
+		}},
+		{name: "sentinel without master", flags: map[string]string{
+			"randomizer-redis-mode": "sentinel",
+			"randomizer-redis-host": "127.0.0.1:26379",
+		}},
+		{name: "cluster with database", flags: map[string]string{
+			"randomizer-redis-mode":     "cluster",
+			"randomizer-redis-host":     "127.0.0.1:6379",
+			"randomizer-redis-database": "3",
+		}},
+		{name: "cluster with database in url", flags: map[string]string{
+			"randomizer-redis-mode": "cluster",
+			"randomizer-redis-url":  "redis://127.0.0.1:6379/3",
+		}},
+		{name: "client certificate without key", flags: map[string]string{
+			"randomizer-redis-host":       "127.0.0.1:6379",
+			"randomizer-redis-tls-enable": "true",
+			"randomizer-redis-tls-cert":   filepath.Join(dir, "cert.pem"),
+		}},
+		{name: "CA file without certificates", flags: map[string]string{
+			"randomizer-redis-host":       "127.0.0.1:6379",
+			"randomizer-redis-tls-enable": "true",
+			"randomizer-redis-tls-ca":     writeTestRedisFile(t, dir, "ca.pem", []byte("not a certificate")),
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if client, e := newRedisClient(newTestContext(t, tt.flags)); e == nil {
+				client.Close()
+				t.Fatal("redis client options must be rejected")
+			}
+		})
+	}
+}
+
+func TestRedisClientSentinel(t *testing.T) {
+	srv := miniredis.RunT(t)
+	srv.RequireUserAuth("alice", "secret")
+	srv.Set("releases", "1")
+
+	host, port, e := net.SplitHostPort(srv.Addr())
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	var mu sync.Mutex
+	var auth []string
+
+	sentinel := newTestRESPServer(t, func(args []string) string {
+		switch strings.ToLower(args[0]) {
+		case "auth":
+			mu.Lock()
+			auth = append(auth, args[1:]...)
+			mu.Unlock()
+			return "+OK\r\n"
+		case "sentinel":
+			switch strings.ToLower(args[1]) {
+			case "get-master-addr-by-name":
+				if args[2] != "mymaster" {
+					return "*-1\r\n"
+				}
+				return respArray(host, port)
+			case "sentinels":
+				return "*0\r\n"
+			}
+		case "subscribe":
+			var rsp string
+			for i, channel := range args[1:] {
+				rsp += fmt.Sprintf("*3\r\n$9\r\nsubscribe\r\n$%d\r\n%s\r\n:%d\r\n", len(channel), channel, i+1)
+			}
+			return rsp
+		}
+
+		return testRESPDefault(args)
+	})
+
+	client, e := newRedisClient(newTestContext(t, map[string]string{
+		"randomizer-redis-mode":            "sentinel",
+		"randomizer-redis-url":             "redis://:sentinel-secret@" + sentinel + "/0",
+		"randomizer-redis-sentinel-master": "mymaster",
+		"randomizer-redis-username":        "alice",
+		"randomizer-redis-password":        "secret",
+	}))
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer client.Close()
+
+	if res, e := client.Get(context.Background(), "releases").Result(); e != nil || res != "1" {
+		t.Fatalf("unexpected releases key value %q from the discovered master - %v", res, e)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	// commands and +switch-master subscription use their own connections
+	if len(auth) == 0 {
+		t.Fatal("sentinel must be authenticated with the url password")
+	}
+
+	for _, password := range auth {
+		if password != "sentinel-secret" {
+			t.Fatalf("sentinel must be authenticated with the url password, got %v", auth)
+		}
+	}
+}
+
+func TestRedisClientCluster(t *testing.T) {
+	srv := miniredis.RunT(t)
+	srv.Set("releases", "1")
+
+	host, port, e := net.SplitHostPort(srv.Addr())
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	var slots atomic.Int32
+
+	// the seed node owns no slots, so keys are served by the node from CLUSTER SLOTS only
+	seed := newTestRESPServer(t, func(args []string) string {
+		if strings.EqualFold(args[0], "cluster") && strings.EqualFold(args[1], "slots") {
+			slots.Add(1)
+			return fmt.Sprintf("*1\r\n*3\r\n:0\r\n:16383\r\n*2\r\n$%d\r\n%s\r\n:%s\r\n", len(host), host, port)
+		}
+
+		return testRESPDefault(args)
+	})
+
+	client, e := newRedisClient(newTestContext(t, map[string]string{
+		"randomizer-redis-mode": "cluster",
+		"randomizer-redis-url":  "redis://" + seed,
+	}))
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer client.Close()
+
+	if _, ok := client.(*redis.ClusterClient); !ok {
+		t.Fatalf("cluster mode must use the cluster client, got %T", client)
+	}
+
+	if res, e := client.Get(context.Background(), "releases").Result(); e != nil || res != "1" {
+		t.Fatalf("unexpected releases key value %q from the slot owner - %v", res, e)
+	}
+
+	if slots.Load() == 0 {
+		t.Fatal("cluster slots must be loaded from the seed node")
+	}
+}
+
+//
+
+func newTestRedisCA(t *testing.T) (*x509.Certificate, *ecdsa.PrivateKey, []byte) {
+	t.Helper()
+
+	key, e := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	tpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: "alice test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+
+	der, e := x509.CreateCertificate(rand.Reader, tpl, tpl, &key.PublicKey, key)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	cert, e := x509.ParseCertificate(der)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	return cert, key, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+// issueTestRedisCert returns the certificate and its PEM encoded certificate and key
+func issueTestRedisCert(t *testing.T, ca *x509.Certificate, caKey *ecdsa.PrivateKey, usage x509.ExtKeyUsage,
+	ips ...net.IP) (tls.Certificate, []byte, []byte) {
+	t.Helper()
+
+	key, e := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	tpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: "alice test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		IPAddresses:  ips,
+	}
+
+	der, e := x509.CreateCertificate(rand.Reader, tpl, ca, &key.PublicKey, caKey)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	kder, e := x509.MarshalECPrivateKey(key)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: kder})
+
+	pair, e := tls.X509KeyPair(certPEM, keyPEM)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	return pair, certPEM, keyPEM
+}
+
+func writeTestRedisFile(t *testing.T, dir, name string, data []byte) string {
+	t.Helper()
+
+	path := filepath.Join(dir, name)
+	if e := os.WriteFile(path, data, 0600); e != nil {
+		t.Fatal(e)
+	}
+
+	return path
+}
+
+// newTestRESPServer starts the minimal RESP2 stand-in for sentinel and cluster commands
+// which miniredis does not implement; handler returns the raw reply
+func newTestRESPServer(t *testing.T, handler func(args []string) string) string {
+	t.Helper()
+
+	ln, e := net.Listen("tcp", "127.0.0.1:0")
+	if e != nil {
+		t.Fatal(e)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, e := ln.Accept()
+			if e != nil {
+				return
+			}
+
+			go serveTestRESP(conn, handler)
+		}
+	}()
+
+	return ln.Addr().String()
+}
+
+func serveTestRESP(conn net.Conn, handler func(args []string) string) {
+	defer conn.Close()
+	rd := bufio.NewReader(conn)
+
+	for {
+		args, e := readTestRESPCommand(rd)
+		if e != nil {
+			return
+		}
+
+		if _, e = conn.Write([]byte(handler(args))); e != nil {
+			return
+		}
+	}
+}
+
+// readTestRESPCommand reads the command sent as an array of bulk strings
+func readTestRESPCommand(rd *bufio.Reader) (args []string, e error) {
+	var line string
+	if line, e = rd.ReadString('\n'); e != nil {
+		return
+	}
+
+	var n int
+	if _, e = fmt.Sscanf(line, "*%d\r\n", &n); e != nil {
+		return
+	}
+
+	for i := 0; i < n; i++ {
+		if line, e = rd.ReadString('\n'); e != nil {
+			return
+		}
+
+		var size int
+		if _, e = fmt.Sscanf(line, "$%d\r\n", &size); e != nil {
+			return
+		}
+
+		arg := make([]byte, size+2)
+		if _, e = io.ReadFull(rd, arg); e != nil {
+			return
+		}
+
+		args = append(args, string(arg[:size]))
+	}
+
+	if len(args) == 0 {
+		e = errors.New("empty command")
+	}
+
+	return
+}
+
+// testRESPDefault answers the connection setup commands; HELLO is rejected,
+// so the client falls back to RESP2 and AUTH
+func testRESPDefault(args []string) string {
+	switch strings.ToLower(args[0]) {
+	case "auth", "client", "select":
+		return "+OK\r\n"
+	case "ping":
+		return "+PONG\r\n"
+	default:
+		return "-ERR unknown command '" + args[0] + "'\r\n"
+	}
+}
+
+func respArray(items ...string) string {
+	rsp := fmt.Sprintf("*%d\r\n", len(items))
+	for _, item := range items {
+		rsp += fmt.Sprintf("$%d\r\n%s\r\n", len(item), item)
+	}
+
+	return rsp
+}
@@ -0,0 +1,47 @@
+package anilibria
+
+import (
+	"fmt"
+
+	"github.com/rs/zerolog"
+	"github.com/urfave/cli/v2"
+)
+
+type ReleaseSourceType uint8
+
+const (
+	RSTRedis ReleaseSourceType = iota
+	RSTFile
+	RSTHTTP
+)
+
+var Storst = map[string]ReleaseSourceType{
+	"redis": RSTRedis,
+	"file":  RSTFile,
+	"http":  RSTHTTP,
+}
+
+// ReleaseSource loads release objects for the randomizer
+type ReleaseSource interface {
+	// Fetch calls fn for every parsed batch of releases; the error is returned
+	// if releases could not be loaded and the current releases must be kept
+	Fetch(done func() <-chan struct{}, fn func(Releases)) error
+	Close() error
+}
+
+func newReleaseSource(c *cli.Context, log *zerolog.Logger) (_ ReleaseSource, e error) {
+	rst, ok := Storst[c.String("randomizer-source")]
+	if !ok {
+		e = fmt.Errorf("unknown randomizer source %s", c.String("randomizer-source"))
+		return
+	}
+
+	switch rst {
+	case RSTFile:
+		return newFileSource(c, log)
+	case RSTHTTP:
+		return newHTTPSource(c, log)
+	default:
+		return newRedisSource(c, log)
+	}
+}
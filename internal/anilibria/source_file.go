@@ -0,0 +1,127 @@
+package anilibria
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/rs/zerolog"
+	"github.com/urfave/cli/v2"
+)
+
+// maximum size of the one NDJSON line
+const maxFileSourceLine = 16 * 1024 * 1024
+
+// fileSource reads releases from the local file which is re-read on every update;
+// .ndjson and .jsonl files contain one release per line, other files contain
+// the JSON array of releases or the object of releases (as redis chunks do)
+type fileSource struct {
+	log  *zerolog.Logger
+	path string
+}
+
+func newFileSource(c *cli.Context, log *zerolog.Logger) (_ *fileSource, e error) {
+	if c.String("randomizer-source-file") == "" {
+		e = errors.New("randomizer-source-file must be defined for the file source")
+		return
+	}
+
+	return &fileSource{
+		log:  log,
+		path: c.String("randomizer-source-file"),
+	}, e
+}
+
+func (*fileSource) Close() error {
+	return nil
+}
+
+func (m *fileSource) Fetch(_ func() <-chan struct{}, fn func(Releases)) (e error) {
+	m.log.Info().Msg("staring release parsing from file " + m.path)
+
+	switch filepath.Ext(m.path) {
+	case ".ndjson", ".jsonl":
+		return m.fetchNDJSON(fn)
+	default:
+		return m.fetchJSON(fn)
+	}
+}
+
+func (m *fileSource) fetchJSON(fn func(Releases)) (e error) {
+	var payload []byte
+	if payload, e = os.ReadFile(m.path); e != nil {
+		return
+	}
+
+	if payload = bytes.TrimSpace(payload); len(payload) == 0 {
+		return errors.New("releases file is empty")
+	}
+
+	if payload[0] != '[' {
+		var releases Releases
+		if e = json.Unmarshal(payload, &releases); e != nil {
+			return
+		}
+
+		fn(releases)
+		return
+	}
+
+	var list []*Release
+	if e = json.Unmarshal(payload, &list); e != nil {
+		return
+	}
+
+	fn(releasesFromList(list))
+	return
+}
+
+func (m *fileSource) fetchNDJSON(fn func(Releases)) (e error) {
+	var file *os.File
+	if file, e = os.Open(m.path); e != nil {
+		return
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, maxFileSourceLine)
+
+	releases := make(Releases)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+
+		release := new(Release)
+		if e = json.Unmarshal(scanner.Bytes(), release); e != nil {
+			m.log.Warn().Msgf("could not parse release on line %d - %s", line, e.Error())
+			continue
+		}
+
+		releases[strconv.Itoa(line)] = release
+	}
+
+	if e = scanner.Err(); e != nil {
+		return
+	} else if len(releases) == 0 {
+		return errors.New("there are no valid releases in " + m.path)
+	}
+
+	fn(releases)
+	return
+}
+
+func releasesFromList(list []*Release) Releases {
+	releases := make(Releases, len(list))
+	for i, release := range list {
+		if release != nil {
+			releases[strconv.Itoa(i)] = release
+		}
+	}
+
+	return releases
+}
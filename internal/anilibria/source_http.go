@@ -0,0 +1,191 @@
+package anilibria
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/urfave/cli/v2"
+	"github.com/valyala/fasthttp"
+)
+
+// httpSource crawls the paged apiv1 method of the upstream (query=list by default);
+// releases are updated only if all pages have been crawled
+type httpSource struct {
+	log    *zerolog.Logger
+	client *fasthttp.Client
+
+	url     string
+	host    string
+	args    *fasthttp.Args
+	perPage int
+
+	maxPages int
+	timeout  time.Duration
+}
+
+// apiv1 response with the list of releases in data or in data.items
+type httpSourceResponse struct {
+	Status bool
+	Data   json.RawMessage
+	Error  *struct {
+		Code    int
+		Message string
+	}
+}
+
+func newHTTPSource(c *cli.Context, log *zerolog.Logger) (_ *httpSource, e error) {
+	hs := &httpSource{
+		log: log,
+		client: &fasthttp.Client{
+			Name:                fmt.Sprintf("%s/%s", c.App.Name, c.App.Version),
+			MaxResponseBodySize: 64 * 1024 * 1024,
+		},
+
+		url:     c.String("randomizer-source-url"),
+		host:    c.String("proxy-dst-host"),
+		args:    fasthttp.AcquireArgs(),
+		perPage: c.Int("randomizer-source-per-page"),
+
+		maxPages: c.Int("randomizer-source-max-pages"),
+		timeout:  c.Duration("randomizer-source-timeout"),
+	}
+
+	switch {
+	case hs.url == "":
+		e = errors.New("randomizer-source-url must be defined for the http source")
+	case hs.perPage <= 0 || hs.maxPages <= 0:
+		e = errors.New("randomizer-source-per-page and randomizer-source-max-pages must be greater than zero")
+	}
+
+	if e != nil {
+		fasthttp.ReleaseArgs(hs.args)
+		return
+	}
+
+	hs.args.Parse(c.String("randomizer-source-args"))
+	return hs, e
+}
+
+func (m *httpSource) Close() error {
+	fasthttp.ReleaseArgs(m.args)
+	m.client.CloseIdleConnections()
+	return nil
+}
+
+func (m *httpSource) Fetch(done func() <-chan struct{}, fn func(Releases)) (e error) {
+	m.log.Info().Msg("staring release crawling from " + m.url)
+
+	pages := make([]Releases, 0)
+
+	// pages shift if releases are published while crawling, so the same release could be met twice
+	seen := make(map[uint]struct{})
+	var duplicates int
+
+	for page := 1; page <= m.maxPages; page++ {
+		select {
+		case <-done():
+			return errors.New("release crawling has been interrupted by global abort()")
+		default:
+			m.log.Trace().Msgf("crawling page %d...", page)
+		}
+
+		var releases []*Release
+		if releases, e = m.fetchPage(page); e != nil {
+			return fmt.Errorf("could not crawl page %d - %s", page, e.Error())
+		}
+
+		unique := make([]*Release, 0, len(releases))
+		for _, release := range releases {
+			if release == nil {
+				continue
+			} else if _, ok := seen[release.Id]; ok {
+				duplicates++
+				continue
+			}
+
+			seen[release.Id] = struct{}{}
+			unique = append(unique, release)
+		}
+
+		pages = append(pages, releasesFromList(unique))
+
+		if len(releases) < m.perPage {
+			m.log.Info().Msgf("releases have been crawled from %d pages", page)
+			break
+		} else if page == m.maxPages {
+			m.log.Warn().Msgf("release crawling has been stopped on randomizer-source-max-pages %d", page)
+		}
+	}
+
+	if duplicates != 0 {
+		m.log.Warn().Msgf("%d duplicated releases have been skipped while crawling", duplicates)
+	}
+
+	for _, releases := range pages {
+		fn(releases)
+	}
+
+	return
+}
+
+func (m *httpSource) fetchPage(page int) (_ []*Release, e error) {
+	req, rsp := fasthttp.AcquireRequest(), fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseRequest(req)
+	defer fasthttp.ReleaseResponse(rsp)
+
+	args := fasthttp.AcquireArgs()
+	defer fasthttp.ReleaseArgs(args)
+
+	m.args.CopyTo(args)
+	args.Set("page", strconv.Itoa(page))
+	args.Set("perPage", strconv.Itoa(m.perPage))
+
+	req.SetRequestURI(m.url)
+	req.Header.SetMethod(fasthttp.MethodPost)
+	req.Header.SetContentType("application/x-www-form-urlencoded")
+	req.SetBody(args.QueryString())
+
+	if m.host != "" {
+		req.Header.SetHost(m.host)
+		req.UseHostHeader = true
+	}
+
+	if e = m.client.DoTimeout(req, rsp, m.timeout); e != nil {
+		return
+	}
+
+	if rsp.StatusCode() != fasthttp.StatusOK {
+		e = fmt.Errorf("upstream respond with status %d", rsp.StatusCode())
+		return
+	}
+
+	var apirsp httpSourceResponse
+	if e = json.Unmarshal(rsp.Body(), &apirsp); e != nil {
+		return
+	}
+
+	if !apirsp.Status {
+		if apirsp.Error != nil {
+			e = fmt.Errorf("upstream respond with error %d - %s", apirsp.Error.Code, apirsp.Error.Message)
+		} else {
+			e = errors.New("upstream respond with false status")
+		}
+		return
+	}
+
+	var releases []*Release
+	if len(apirsp.Data) != 0 && apirsp.Data[0] == '[' {
+		e = json.Unmarshal(apirsp.Data, &releases)
+		return releases, e
+	}
+
+	var data struct {
+		Items []*Release
+	}
+	e = json.Unmarshal(apirsp.Data, &data)
+	return data.Items, e
+}
@@ -0,0 +1,149 @@
+package anilibria
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+
+	futils "github.com/gofiber/fiber/v2/utils"
+	"github.com/klauspost/compress/zstd"
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog"
+	"github.com/urfave/cli/v2"
+)
+
+// redisSource reads releases from the legacy layout: the releases key contains
+// chunks count and every chunk is stored in the releases key + chunk index
+type redisSource struct {
+	log *zerolog.Logger
+
+	rctx    context.Context
+	rclient redis.UniversalClient
+
+	releasesKey string
+	decoder     *zstd.Decoder
+}
+
+func newRedisSource(c *cli.Context, log *zerolog.Logger) (_ *redisSource, e error) {
+	var dec *zstd.Decoder
+	if c.Bool("randomizer-redis-zstd-enable") {
+		dec, _ = zstd.NewReader(nil, zstd.WithDecoderConcurrency(0))
+	}
+
+	var rclient redis.UniversalClient
+	if rclient, e = newRedisClient(c); e != nil {
+		return
+	}
+
+	return &redisSource{
+		log: log,
+
+		rctx:    context.Background(),
+		rclient: rclient,
+
+		releasesKey: c.String("randomizer-releaseskey"),
+		decoder:     dec,
+	}, e
+}
+
+func (m *redisSource) Close() error {
+	return m.rclient.Close()
+}
+
+func (m *redisSource) Fetch(done func() <-chan struct{}, fn func(Releases)) (e error) {
+	var chunks int
+	if chunks, e = m.peekReleaseKeyChunks(); e != nil {
+		return
+	} else if chunks == 0 {
+		e = errors.New("invalid chunks count was responded by redis client or converted by golang")
+		return
+	}
+	m.log.Trace().Msgf("release key says about %d chunks", chunks)
+	m.log.Info().Msgf("staring release parsing from redis with %d chunks", chunks)
+
+	var res string
+	var errs []string
+
+	for i := 0; i < chunks; i++ {
+		select {
+		case <-done():
+			e = errors.New("chunk parsing has been interrupted by global abort()")
+			return
+		default:
+			m.log.Trace().Msgf("parsing chunk %d/%d...", i, chunks)
+		}
+
+		// get compressed chunk response from redis
+		if res, e = m.rclient.Get(m.rctx, m.releasesKey+strconv.Itoa(i)).Result(); e == redis.Nil {
+			e = fmt.Errorf("given chunk number %d is not exists", i)
+			m.log.Warn().Msg(e.Error())
+			errs = append(errs, e.Error())
+			continue
+		} else if e != nil {
+			m.log.Warn().Msg("an error occurred while peeking a releases chunk - " + e.Error())
+			errs = append(errs, e.Error())
+			continue
+		}
+
+		// decompress chunk response from redis
+		var dres []byte
+		if dres, e = m.decompressPayload(futils.UnsafeBytes(res)); e != nil {
+			m.log.Warn().Msg("an error occurred while decompress redis response - " + e.Error())
+			errs = append(errs, e.Error())
+			continue
+		}
+
+		// get json formated response from decompressed response
+		var releasesChunk Releases
+		if e = json.Unmarshal(dres, &releasesChunk); e != nil {
+			m.log.Warn().Msg("an error occurred while unmarshal release chunk - " + e.Error())
+			errs = append(errs, e.Error())
+			continue
+		}
+
+		fn(releasesChunk)
+	}
+
+	if errslen := len(errs); errslen != 0 {
+		m.log.Error().Msgf("%d chunks were corrupted, data from them did not get into the cache", errslen)
+		m.log.Error().Msg("release redis extraction process errors:")
+
+		for _, err := range errs {
+			m.log.Error().Msg(err)
+		}
+	}
+
+	m.log.Info().Msgf("releases have been parsed from %d (of %d) chunks", chunks-len(errs), chunks)
+	return nil
+}
+
+func (m *redisSource) peekReleaseKeyChunks() (_ int, e error) {
+	var res string
+	if res, e = m.rclient.Get(m.rctx, m.releasesKey).Result(); e == redis.Nil {
+		e = errors.New("no such release key in redis; is it correct - " + m.releasesKey)
+		return
+	} else if e != nil {
+		return
+	} else if res == "" {
+		e = errors.New("redis client respond with an empty string; is release key is alive?")
+		return
+	}
+
+	var dres []byte
+	if dres, e = m.decompressPayload(futils.UnsafeBytes(res)); e != nil {
+		m.log.Warn().Msg("an error occurred while decompress response redis response - " + e.Error())
+		return
+	}
+
+	return strconv.Atoi(futils.UnsafeString(dres))
+}
+
+func (m *redisSource) decompressPayload(payload []byte) ([]byte, error) {
+	if m.decoder == nil {
+		return payload, nil
+	}
+
+	return m.decoder.DecodeAll(payload, nil)
+}
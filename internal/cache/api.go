@@ -7,6 +7,7 @@ import (
 	"math"
 	"time"
 
+	"github.com/allegro/bigcache/v3"
 	"github.com/jedib0t/go-pretty/v6/table"
 )
 
@@ -61,6 +62,14 @@ func (m *Cache) ApiDumpKeys() io.Reader {
 
 func (m *Cache) ApiPurge(country, key string) error {
 	zone := m.cacheZoneByISO(country)
+
+	// variants are orphaned without the raw entry, so they are purged regardless of its deletion result
+	for _, enc := range m.encodings {
+		if e := m.pools[zone].Delete(variantKey(enc, key)); e != nil && !errors.Is(e, bigcache.ErrEntryNotFound) {
+			m.log.Warn().Msgf("could not purge %s variant - %s", Enctos[enc], e.Error())
+		}
+	}
+
 	return m.pools[zone].Delete(key)
 }
 
@@ -5,12 +5,14 @@ import (
 	"errors"
 	"io"
 	"strings"
+	"time"
 
 	"github.com/allegro/bigcache/v3"
 	"github.com/anilibria/alice/internal/utils"
 	"github.com/klauspost/compress/s2"
 	"github.com/rs/zerolog"
 	"github.com/urfave/cli/v2"
+	"github.com/valyala/fasthttp"
 )
 
 type cacheZone uint8
@@ -29,6 +31,13 @@ type Cache struct {
 	pools      map[cacheZone]*bigcache.BigCache
 	quarantine map[string]bool
 
+	lifeWindow  time.Duration
+	staleWindow time.Duration
+	ttlPolicy   *TTLPolicy
+
+	encodings      []Encoding
+	encodingsEager bool
+
 	log  *zerolog.Logger
 	done func() <-chan struct{}
 }
@@ -40,16 +49,42 @@ func NewCache(c context.Context) (cache *Cache, e error) {
 
 	cache = new(Cache)
 	cache.log, cache.done = log, c.Done
+	cache.lifeWindow, cache.staleWindow =
+		cli.Duration("cache-life-window"), cli.Duration("cache-stale-window")
+
+	// bigcache evicts entries with one second precision
+	if cache.lifeWindow < time.Second {
+		e = errors.New("cache-life-window must be at least 1s")
+		return
+	} else if cache.staleWindow != 0 && cache.staleWindow < time.Second {
+		e = errors.New("cache-stale-window must be 0 or at least 1s")
+		return
+	}
+
+	if cache.ttlPolicy, e = NewTTLPolicy(cli.String("cache-ttl-policy")); e != nil {
+		return
+	}
+
+	if cache.encodings, e = parseEncodings(cli.String("cache-encodings")); e != nil {
+		return
+	}
+	cache.encodingsEager = cli.Bool("cache-encodings-eager")
+
+	// entries with the longest ttl must not be evicted before their expiration
+	window := cache.lifeWindow
+	if maxttl := cache.ttlPolicy.maxTTL(); maxttl > window {
+		window = maxttl
+	}
 
 	// create default cache zone
 	cache.pools = make(map[cacheZone]*bigcache.BigCache)
-	if cache.pools[defaultCache], e = createBigCache(cli, log); e != nil {
+	if cache.pools[defaultCache], e = createBigCache(cli, log, window); e != nil {
 		return
 	}
 
 	// create quarantine cache zone
 	if countries := cli.String("cache-rfngroup-countries"); countries != "" {
-		if cache.pools[quarantineCache], e = createBigCache(cli, log); e != nil {
+		if cache.pools[quarantineCache], e = createBigCache(cli, log, window); e != nil {
 			return
 		}
 
@@ -73,12 +108,13 @@ func NewCache(c context.Context) (cache *Cache, e error) {
 	return
 }
 
-func createBigCache(cli *cli.Context, log *zerolog.Logger) (*bigcache.BigCache, error) {
+func createBigCache(cli *cli.Context, log *zerolog.Logger, window time.Duration) (*bigcache.BigCache, error) {
 	return bigcache.New(context.Background(), bigcache.Config{
 		Shards:           cli.Int("cache-shards"),
 		HardMaxCacheSize: cli.Int("cache-max-size"),
 
-		LifeWindow:  cli.Duration("cache-life-window"),
+		// expired entries are kept in the stale grace area
+		LifeWindow:  window + cli.Duration("cache-stale-window"),
 		CleanWindow: cli.Duration("cache-clean-window"),
 
 		MaxEntriesInWindow: 1000 * 10 * 60,
@@ -102,6 +138,10 @@ func (m *Cache) cacheZoneByISO(iso string) cacheZone {
 	return defaultCache
 }
 
+func (m *Cache) ZoneByISO(iso string) string {
+	return zoneHumanize[m.cacheZoneByISO(iso)]
+}
+
 func (m *Cache) Bootstrap() {
 	<-m.done()
 	m.log.Info().Msg("internal abort() has been caught; initiate application closing...")
@@ -118,22 +158,63 @@ func (m *Cache) Bootstrap() {
 	}
 }
 
-func (m *Cache) IsCached(country, key string) (_ bool, e error) {
+func (m *Cache) IsCached(country, key string) (_ EntryState, e error) {
+	var info EntryInfo
+	info, e = m.Info(country, key)
+	return info.State, e
+}
+
+func (m *Cache) Info(country, key string) (info EntryInfo, e error) {
 	zone := m.cacheZoneByISO(country)
 
-	if _, e = m.pools[zone].Get(key); e != nil && errors.Is(e, bigcache.ErrEntryNotFound) {
-		return false, nil
+	var entry []byte
+	if entry, e = m.pools[zone].Get(key); e != nil && errors.Is(e, bigcache.ErrEntryNotFound) {
+		return info, nil
 	} else if e != nil {
 		return
 	}
 
-	return true, nil
+	var hdr entryHeader
+	if e = hdr.unmarshal(entry); e != nil {
+		return
+	}
+
+	info.State, info.StoredAt, info.Hash =
+		hdr.state(m.staleWindow), time.Unix(hdr.storedAt, 0), hdr.hash
+	return
+}
+
+// TTL returns entry lifetime for the request args by cache-ttl-policy
+func (m *Cache) TTL(args *fasthttp.Args) time.Duration {
+	if ttl := m.ttlPolicy.Lookup(args); ttl != 0 {
+		return ttl
+	}
+
+	return m.lifeWindow
 }
 
-func (m *Cache) Cache(country, key string, payload []byte) error {
+func (m *Cache) Cache(country, key string, payload []byte, ttl time.Duration) error {
 	zone := m.cacheZoneByISO(country)
 
-	return m.setCompressed(zone, key, payload)
+	return m.setCompressed(zone, key, payload, ttl)
+}
+
+// CacheWithVariants caches the payload and builds its encoded variants if cache-encodings-eager is set;
+// otherwise encoded variants are outdated now and will be rebuilt on the first use
+func (m *Cache) CacheWithVariants(country, key string, payload []byte, ttl time.Duration) (e error) {
+	zone := m.cacheZoneByISO(country)
+
+	if e = m.setCompressed(zone, key, payload, ttl); e != nil || !m.encodingsEager {
+		return
+	}
+
+	var entry []byte
+	if entry, e = m.pools[zone].Get(key); e != nil {
+		return
+	}
+
+	m.buildVariants(zone, key, entry)
+	return
 }
 
 func (m *Cache) Write(country, key string, w io.Writer) error {
@@ -142,22 +223,37 @@ func (m *Cache) Write(country, key string, w io.Writer) error {
 	return m.writeDecompressed(zone, key, w)
 }
 
-func (m *Cache) setCompressed(zone cacheZone, key string, payload []byte) error {
-	cmp := s2.EncodeSnappyBetter(nil, payload)
+func (m *Cache) setCompressed(zone cacheZone, key string, payload []byte, ttl time.Duration) error {
+	entry := make([]byte, entryHeaderSize+s2.MaxEncodedLen(len(payload)))
+
+	now := time.Now()
+	hdr := entryHeader{
+		storedAt:  now.Unix(),
+		expiresAt: now.Add(ttl).UnixMilli(),
+		hash:      payloadHash(payload),
+	}
+	hdr.marshal(entry)
+
+	cmp := s2.EncodeSnappyBetter(entry[entryHeaderSize:], payload)
 
 	if zerolog.GlobalLevel() <= zerolog.DebugLevel {
 		m.log.Trace().Msgf("compressed from %d to %d bytes", len(payload), len(cmp))
 	}
 
-	return m.pools[zone].Set(key, cmp)
+	return m.pools[zone].Set(key, entry[:entryHeaderSize+len(cmp)])
 }
 
 func (m *Cache) writeDecompressed(zone cacheZone, key string, w io.Writer) (e error) {
-	var cmp, decmp []byte
-	if cmp, e = m.pools[zone].Get(key); e != nil {
+	var entry, cmp, decmp []byte
+	if entry, e = m.pools[zone].Get(key); e != nil {
 		return
 	}
 
+	if len(entry) < entryHeaderSize {
+		return errEntryCorrupted
+	}
+	cmp = entry[entryHeaderSize:]
+
 	if decmp, e = s2.Decode(nil, cmp); e != nil {
 		return
 	}
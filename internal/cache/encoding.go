@@ -0,0 +1,150 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/allegro/bigcache/v3"
+	"github.com/klauspost/compress/s2"
+	"github.com/rs/zerolog"
+	"github.com/valyala/fasthttp"
+)
+
+type Encoding uint8
+
+const (
+	EncIdentity Encoding = iota
+	EncBrotli
+	EncZstd
+	EncGzip
+)
+
+var Stoenc = map[string]Encoding{
+	"br":   EncBrotli,
+	"zstd": EncZstd,
+	"gzip": EncGzip,
+}
+
+var Enctos = map[Encoding]string{
+	EncIdentity: "identity",
+	EncBrotli:   "br",
+	EncZstd:     "zstd",
+	EncGzip:     "gzip",
+}
+
+// cache-encodings format - br,zstd,gzip (in order of server preference)
+func parseEncodings(raw string) (encodings []Encoding, e error) {
+	for _, name := range strings.Split(raw, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+
+		enc, ok := Stoenc[name]
+		if !ok {
+			e = fmt.Errorf("unsupported content encoding %s", name)
+			return
+		}
+
+		encodings = append(encodings, enc)
+	}
+
+	return
+}
+
+// Encodings returns enabled content encodings in order of server preference
+func (m *Cache) Encodings() []Encoding {
+	return m.encodings
+}
+
+// WriteEncoded writes the pre-compressed variant of the entry;
+// missing or outdated variant is built from the raw entry and stored next to it
+func (m *Cache) WriteEncoded(country, key string, enc Encoding, w io.Writer) (e error) {
+	zone := m.cacheZoneByISO(country)
+
+	if enc == EncIdentity {
+		return m.writeDecompressed(zone, key, w)
+	}
+
+	var entry []byte
+	if entry, e = m.pools[zone].Get(key); e != nil {
+		return
+	}
+
+	var hdr entryHeader
+	if e = hdr.unmarshal(entry); e != nil {
+		return
+	}
+
+	var variant []byte
+	if variant, e = m.pools[zone].Get(variantKey(enc, key)); e != nil && !errors.Is(e, bigcache.ErrEntryNotFound) {
+		return
+	}
+
+	// variant has been built for the current entry, so respond with a plain copy
+	var vhdr entryHeader
+	if e == nil && vhdr.unmarshal(variant) == nil && vhdr.hash == hdr.hash {
+		_, e = w.Write(variant[entryHeaderSize:])
+		return
+	}
+
+	if variant, e = m.buildVariant(zone, key, enc, &hdr, entry[entryHeaderSize:]); e != nil {
+		return
+	}
+
+	_, e = w.Write(variant[entryHeaderSize:])
+	return
+}
+
+func (m *Cache) buildVariant(zone cacheZone, key string, enc Encoding, hdr *entryHeader, cmp []byte) (_ []byte, e error) {
+	var payload []byte
+	if payload, e = s2.Decode(nil, cmp); e != nil {
+		return
+	}
+
+	variant := make([]byte, entryHeaderSize, entryHeaderSize+len(payload))
+	hdr.marshal(variant)
+
+	// variants are built in the request path, so the best compression levels are too slow
+	switch enc {
+	case EncBrotli:
+		variant = fasthttp.AppendBrotliBytesLevel(variant, payload, fasthttp.CompressBrotliDefaultCompression)
+	case EncZstd:
+		variant = fasthttp.AppendZstdBytesLevel(variant, payload, fasthttp.CompressZstdDefault)
+	case EncGzip:
+		variant = fasthttp.AppendGzipBytesLevel(variant, payload, fasthttp.CompressDefaultCompression)
+	default:
+		e = fmt.Errorf("BUG: unsupported content encoding %d", enc)
+		return
+	}
+
+	if zerolog.GlobalLevel() <= zerolog.DebugLevel {
+		m.log.Trace().Msgf("%s variant built from %d to %d bytes", Enctos[enc], len(payload), len(variant)-entryHeaderSize)
+	}
+
+	// the variant is valid even if it could not be stored, so the response is not failed
+	if e = m.pools[zone].Set(variantKey(enc, key), variant); e != nil {
+		m.log.Warn().Msgf("could not store %s variant - %s", Enctos[enc], e.Error())
+	}
+
+	return variant, nil
+}
+
+func (m *Cache) buildVariants(zone cacheZone, key string, entry []byte) {
+	var hdr entryHeader
+	if e := hdr.unmarshal(entry); e != nil {
+		m.log.Warn().Msg("could not build encoded variants - " + e.Error())
+		return
+	}
+
+	for _, enc := range m.encodings {
+		if _, e := m.buildVariant(zone, key, enc, &hdr, entry[entryHeaderSize:]); e != nil {
+			m.log.Warn().Msgf("could not build %s variant - %s", Enctos[enc], e.Error())
+		}
+	}
+}
+
+func variantKey(enc Encoding, key string) string {
+	return Enctos[enc] + ":::" + key
+}
@@ -0,0 +1,80 @@
+package cache
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/fnv"
+	"time"
+)
+
+type EntryState uint8
+
+const (
+	ESMissing EntryState = iota
+	ESFresh
+	ESStale
+)
+
+var EStos = map[EntryState]string{
+	ESMissing: "missing",
+	ESFresh:   "fresh",
+	ESStale:   "stale",
+}
+
+// entry layout:
+// [0:8]   - unix timestamp of the entry storing
+// [8:16]  - unix timestamp of the entry expiration in milliseconds
+// [16:24] - fnv-1a hash of the uncompressed payload
+// [24:]   - s2 compressed payload
+const entryHeaderSize = 24
+
+var errEntryCorrupted = errors.New("cache entry is corrupted, header is too short")
+
+type entryHeader struct {
+	storedAt  int64
+	expiresAt int64
+	hash      uint64
+}
+
+// EntryInfo describes cached entry without its payload
+type EntryInfo struct {
+	State    EntryState
+	StoredAt time.Time
+	Hash     uint64
+}
+
+func (m *entryHeader) marshal(dst []byte) {
+	binary.BigEndian.PutUint64(dst[0:8], uint64(m.storedAt))
+	binary.BigEndian.PutUint64(dst[8:16], uint64(m.expiresAt))
+	binary.BigEndian.PutUint64(dst[16:24], m.hash)
+}
+
+func (m *entryHeader) unmarshal(entry []byte) error {
+	if len(entry) < entryHeaderSize {
+		return errEntryCorrupted
+	}
+
+	m.storedAt = int64(binary.BigEndian.Uint64(entry[0:8]))
+	m.expiresAt = int64(binary.BigEndian.Uint64(entry[8:16]))
+	m.hash = binary.BigEndian.Uint64(entry[16:24])
+	return nil
+}
+
+func (m *entryHeader) state(stale time.Duration) EntryState {
+	expired := time.Since(time.UnixMilli(m.expiresAt))
+
+	switch {
+	case expired < 0:
+		return ESFresh
+	case expired < stale:
+		return ESStale
+	default:
+		return ESMissing
+	}
+}
+
+func payloadHash(payload []byte) uint64 {
+	h := fnv.New64a()
+	h.Write(payload)
+	return h.Sum64()
+}
@@ -0,0 +1,108 @@
+package cache
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+type ttlRule struct {
+	args *fasthttp.Args
+	ttl  time.Duration
+}
+
+// TTLPolicy is a table of entry lifetimes keyed by apiv1 request args
+type TTLPolicy struct {
+	rules []*ttlRule
+}
+
+// cache-ttl-policy format - query=genres|24h,query=release&id=*|30m
+// all args of the rule must be matched; * matches any non-empty value
+func NewTTLPolicy(policy string) (_ *TTLPolicy, e error) {
+	tp := &TTLPolicy{}
+
+	for _, raw := range strings.Split(policy, ",") {
+		if raw = strings.TrimSpace(raw); raw == "" {
+			continue
+		}
+
+		idx := strings.LastIndexByte(raw, '|')
+		if idx == -1 {
+			e = fmt.Errorf("ttl rule %s has invalid format, ttl is missing", raw)
+			return
+		}
+
+		rule := &ttlRule{args: &fasthttp.Args{}}
+		if rule.ttl, e = time.ParseDuration(raw[idx+1:]); e != nil {
+			e = fmt.Errorf("could not parse ttl of rule %s - %s", raw, e.Error())
+			return
+		} else if rule.ttl < time.Second {
+			e = fmt.Errorf("ttl of rule %s must be at least 1s", raw)
+			return
+		}
+
+		if rule.args.Parse(raw[:idx]); rule.args.Len() == 0 {
+			e = fmt.Errorf("ttl rule %s has no args for matching", raw)
+			return
+		}
+
+		tp.rules = append(tp.rules, rule)
+	}
+
+	return tp, e
+}
+
+// Lookup returns ttl of the most specific matched rule or zero if nothing is matched
+func (m *TTLPolicy) Lookup(args *fasthttp.Args) (ttl time.Duration) {
+	if m == nil || args == nil {
+		return
+	}
+
+	var matched int
+	for _, rule := range m.rules {
+		if rule.args.Len() <= matched || !rule.match(args) {
+			continue
+		}
+
+		ttl, matched = rule.ttl, rule.args.Len()
+	}
+
+	return
+}
+
+func (m *TTLPolicy) maxTTL() (max time.Duration) {
+	for _, rule := range m.rules {
+		if rule.ttl > max {
+			max = rule.ttl
+		}
+	}
+
+	return
+}
+
+func (m *ttlRule) match(args *fasthttp.Args) (ok bool) {
+	ok = true
+
+	m.args.VisitAll(func(key, value []byte) {
+		if !ok {
+			return
+		}
+
+		actual := args.PeekBytes(key)
+		if len(actual) == 0 {
+			ok = false
+			return
+		}
+
+		if len(value) == 1 && value[0] == '*' {
+			return
+		}
+
+		ok = bytes.Equal(actual, value)
+	})
+
+	return
+}
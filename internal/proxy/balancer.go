@@ -0,0 +1,176 @@
+package proxy
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+type BalancerStrategy uint8
+
+const (
+	BSRoundRobin BalancerStrategy = iota
+	BSLeastConn
+	BSHash
+)
+
+var Stobs = map[string]BalancerStrategy{
+	"round-robin": BSRoundRobin,
+	"least-conn":  BSLeastConn,
+	"hash":        BSHash,
+}
+
+// next returns nil if there are no available upstreams;
+// exclude (if not nil) will not be selected
+type balancer interface {
+	next(key []byte, exclude *Upstream) *Upstream
+}
+
+func newBalancer(strategy string, upstreams []*Upstream) (_ balancer, e error) {
+	bs, ok := Stobs[strategy]
+	if !ok {
+		e = fmt.Errorf("unknown balancing strategy %s", strategy)
+		return
+	}
+
+	switch bs {
+	case BSLeastConn:
+		return &leastConnBalancer{upstreams: upstreams}, e
+	case BSHash:
+		return newHashBalancer(upstreams), e
+	default:
+		return newRoundRobinBalancer(upstreams), e
+	}
+}
+
+// smooth weighted round-robin (like nginx does)
+
+type roundRobinBalancer struct {
+	mu        sync.Mutex
+	upstreams []*Upstream
+	current   []int
+}
+
+func newRoundRobinBalancer(upstreams []*Upstream) *roundRobinBalancer {
+	return &roundRobinBalancer{
+		upstreams: upstreams,
+		current:   make([]int, len(upstreams)),
+	}
+}
+
+func (m *roundRobinBalancer) next(_ []byte, exclude *Upstream) *Upstream {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	best, total := -1, 0
+	for i, upstream := range m.upstreams {
+		if upstream == exclude || !upstream.IsAvailable() {
+			continue
+		}
+
+		m.current[i] += upstream.weight
+		total += upstream.weight
+
+		if best == -1 || m.current[i] > m.current[best] {
+			best = i
+		}
+	}
+
+	if best == -1 {
+		return nil
+	}
+
+	m.current[best] -= total
+	return m.upstreams[best]
+}
+
+// least connections with respect to upstream weights
+
+type leastConnBalancer struct {
+	upstreams []*Upstream
+}
+
+func (m *leastConnBalancer) next(_ []byte, exclude *Upstream) (best *Upstream) {
+	var bestActive int64
+
+	for _, upstream := range m.upstreams {
+		if upstream == exclude || !upstream.IsAvailable() {
+			continue
+		}
+
+		active := upstream.Active()
+
+		// active/weight < bestActive/bestWeight without float division
+		if best == nil || active*int64(best.weight) < bestActive*int64(upstream.weight) {
+			best, bestActive = upstream, active
+		}
+	}
+
+	return
+}
+
+// consistent hashing on the cache key
+
+const hashBalancerReplicas = 160
+
+type hashBalancer struct {
+	ring   []uint32
+	owners map[uint32]*Upstream
+
+	fallback *roundRobinBalancer
+}
+
+func newHashBalancer(upstreams []*Upstream) *hashBalancer {
+	hb := &hashBalancer{
+		owners:   make(map[uint32]*Upstream),
+		fallback: newRoundRobinBalancer(upstreams),
+	}
+
+	for _, upstream := range upstreams {
+		for i := 0; i < upstream.weight*hashBalancerReplicas; i++ {
+			point := hashBalancerSum([]byte(upstream.addr + "#" + strconv.Itoa(i)))
+
+			if _, ok := hb.owners[point]; ok {
+				continue
+			}
+
+			hb.owners[point] = upstream
+			hb.ring = append(hb.ring, point)
+		}
+	}
+
+	sort.Slice(hb.ring, func(i, j int) bool {
+		return hb.ring[i] < hb.ring[j]
+	})
+
+	return hb
+}
+
+func (m *hashBalancer) next(key []byte, exclude *Upstream) *Upstream {
+	// requests without cache key (bypassed) have nothing to hash
+	if len(key) == 0 {
+		return m.fallback.next(key, exclude)
+	}
+
+	point := hashBalancerSum(key)
+	idx := sort.Search(len(m.ring), func(i int) bool {
+		return m.ring[i] >= point
+	})
+
+	// walk clockwise until an available upstream is found
+	for i := 0; i < len(m.ring); i++ {
+		if upstream := m.owners[m.ring[(idx+i)%len(m.ring)]]; upstream != exclude && upstream.IsAvailable() {
+			return upstream
+		}
+	}
+
+	return nil
+}
+
+func hashBalancerSum(payload []byte) uint32 {
+	h := fnv.New32a()
+	h.Write(payload)
+	return h.Sum32()
+}
@@ -0,0 +1,181 @@
+package proxy
+
+import (
+	"sync"
+	"time"
+)
+
+type CircuitState uint8
+
+const (
+	CSClosed CircuitState = iota
+	CSOpen
+	CSHalfOpen
+)
+
+var CStos = map[CircuitState]string{
+	CSClosed:   "closed",
+	CSOpen:     "open",
+	CSHalfOpen: "half-open",
+}
+
+type circuitBreaker struct {
+	mu sync.Mutex
+
+	state    CircuitState
+	openedAt time.Time
+
+	failures  int // consecutive failures in closed state
+	successes int // consecutive successes in half-open state
+	probes    int // in-flight requests in half-open state
+
+	threshold   int
+	openTimeout time.Duration
+	maxProbes   int
+}
+
+func newCircuitBreaker(threshold, maxProbes int, openTimeout time.Duration) *circuitBreaker {
+	if maxProbes <= 0 {
+		maxProbes = 1
+	}
+
+	return &circuitBreaker{
+		threshold:   threshold,
+		openTimeout: openTimeout,
+		maxProbes:   maxProbes,
+	}
+}
+
+func (m *circuitBreaker) State() CircuitState {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.state
+}
+
+// available reports if the upstream can receive traffic without changing the breaker state;
+// it's used by balancers for upstream selection
+func (m *circuitBreaker) available() bool {
+	if m.threshold <= 0 {
+		return true
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	switch m.state {
+	case CSOpen:
+		return time.Since(m.openedAt) >= m.openTimeout
+	case CSHalfOpen:
+		return m.probes < m.maxProbes
+	default:
+		return true
+	}
+}
+
+// allow must be followed by the success() or failure() call
+func (m *circuitBreaker) allow() bool {
+	if m.threshold <= 0 {
+		return true
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	switch m.state {
+	case CSOpen:
+		if time.Since(m.openedAt) < m.openTimeout {
+			return false
+		}
+
+		m.state, m.successes, m.probes = CSHalfOpen, 0, 0
+		fallthrough
+	case CSHalfOpen:
+		if m.probes >= m.maxProbes {
+			return false
+		}
+
+		m.probes++
+	}
+
+	return true
+}
+
+func (m *circuitBreaker) success() {
+	if m.threshold <= 0 {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	switch m.state {
+	case CSHalfOpen:
+		m.releaseProbe()
+
+		if m.successes++; m.successes >= m.maxProbes {
+			m.state, m.failures = CSClosed, 0
+		}
+	case CSClosed:
+		m.failures = 0
+	}
+}
+
+func (m *circuitBreaker) failure() {
+	if m.threshold <= 0 {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	switch m.state {
+	case CSHalfOpen:
+		m.releaseProbe()
+		m.open()
+	case CSClosed:
+		if m.failures++; m.failures >= m.threshold {
+			m.open()
+		}
+	}
+}
+
+// healthy is called by the active health checker;
+// it does not require allow() call before
+func (m *circuitBreaker) healthy(ok bool) {
+	if m.threshold <= 0 {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	switch {
+	case ok && m.state == CSOpen:
+		// let the real traffic probe the upstream
+		m.state, m.successes, m.probes = CSHalfOpen, 0, 0
+	case ok && m.state == CSClosed:
+		m.failures = 0
+	case !ok && m.state == CSHalfOpen:
+		m.open()
+	case !ok && m.state == CSClosed:
+		if m.failures++; m.failures >= m.threshold {
+			m.open()
+		}
+	case !ok && m.state == CSOpen:
+		// keep the circuit opened for the next period
+		m.openedAt = time.Now()
+	}
+}
+
+func (m *circuitBreaker) open() {
+	m.state, m.openedAt = CSOpen, time.Now()
+	m.failures, m.successes = 0, 0
+}
+
+func (m *circuitBreaker) releaseProbe() {
+	// request could be allowed before the circuit has been half-opened
+	if m.probes > 0 {
+		m.probes--
+	}
+}
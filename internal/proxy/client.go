@@ -1,7 +1,9 @@
 package proxy
 
 import (
+	"crypto/tls"
 	"fmt"
+	"time"
 
 	"github.com/urfave/cli/v2"
 	"github.com/valyala/fasthttp"
@@ -11,14 +13,18 @@ type ProxyClient struct {
 	*fasthttp.HostClient
 }
 
-func NewClient(c *cli.Context) *ProxyClient {
+// tlsConfig is optional; upstream connections are plain TCP if it's nil
+func NewClient(c *cli.Context, addr string, tlsConfig *tls.Config) *ProxyClient {
 	return &ProxyClient{
 		HostClient: &fasthttp.HostClient{
 			// https://developer.mozilla.org/en-US/docs/Web/HTTP/Headers/User-Agent#crawler_and_bot_ua_strings
 			Name: fmt.Sprintf("Mozilla/5.0 (compatible; %s/%s; +https://anilibria.top/support)",
 				c.App.Name, c.App.Version),
 
-			Addr: c.String("proxy-dst-server"),
+			Addr: addr,
+
+			IsTLS:     tlsConfig != nil,
+			TLSConfig: tlsConfig,
 
 			MaxConns: c.Int("proxy-max-conns-per-host"),
 
@@ -43,3 +49,24 @@ func NewClient(c *cli.Context) *ProxyClient {
 		},
 	}
 }
+
+func (m *ProxyClient) UserAgent() string {
+	return m.Name
+}
+
+func (m *ProxyClient) Do(req *fasthttp.Request, rsp *fasthttp.Response) error {
+	m.setScheme(req)
+	return m.HostClient.Do(req, rsp)
+}
+
+func (m *ProxyClient) DoTimeout(req *fasthttp.Request, rsp *fasthttp.Response, timeout time.Duration) error {
+	m.setScheme(req)
+	return m.HostClient.DoTimeout(req, rsp, timeout)
+}
+
+// HostClient refuses requests with a scheme different from the connection one
+func (m *ProxyClient) setScheme(req *fasthttp.Request) {
+	if m.IsTLS {
+		req.URI().SetScheme("https")
+	}
+}
@@ -0,0 +1,107 @@
+package proxy
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/anilibria/alice/internal/cache"
+	"github.com/anilibria/alice/internal/utils"
+	"github.com/gofiber/fiber/v2"
+)
+
+var errFlightTimeout = errors.New("timed out while waiting for coalesced upstream request")
+
+type flight struct {
+	done chan struct{}
+
+	err    error // leader's error, it's returned to waiters instead of the upstream request repeat
+	cached bool  // leader's response has been cached, so waiters could respond from cache
+}
+
+// flightGroup collapses concurrent cache misses of the same key (singleflight-style)
+type flightGroup struct {
+	mu      sync.Mutex
+	flights map[string]*flight
+
+	timeout time.Duration
+}
+
+func newFlightGroup(timeout time.Duration) *flightGroup {
+	return &flightGroup{
+		flights: make(map[string]*flight),
+		timeout: timeout,
+	}
+}
+
+func (m *flightGroup) join(key string) (_ *flight, leader bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if f, ok := m.flights[key]; ok {
+		return f, false
+	}
+
+	f := &flight{done: make(chan struct{})}
+	m.flights[key] = f
+
+	return f, true
+}
+
+func (m *flightGroup) leave(key string, f *flight, err error, cached bool) {
+	m.mu.Lock()
+	delete(m.flights, key)
+	m.mu.Unlock()
+
+	f.err, f.cached = err, cached
+	close(f.done)
+}
+
+func (m *Proxy) proxyCoalescedRequest(c *fiber.Ctx) (e error) {
+	if m.flights == nil || m.IsCacheBypass(c) {
+		return m.ProxyFiberRequest(c)
+	}
+
+	key := c.Context().UserValue(utils.UVCacheKey).(*Key)
+	country := m.countryByRemoteIP(c)
+
+	fkey := m.cache.ZoneByISO(country) + ":" + key.UnsafeString()
+
+	f, leader := m.flights.join(fkey)
+	if leader {
+		return m.leadFlight(c, fkey, f)
+	}
+
+	rlog(c).Debug().Msg("waiting for coalesced upstream request - " + key.UnsafeString())
+
+	timer := time.NewTimer(m.flights.timeout)
+	defer timer.Stop()
+
+	select {
+	case <-f.done:
+	case <-timer.C:
+		return errFlightTimeout
+	}
+
+	// upstream has failed, waiters get the same error (and stale entries if allowed)
+	if f.err != nil {
+		return f.err
+	}
+
+	// leader's response is uncacheable (or it could not be cached or has been evicted),
+	// so waiters proxy their requests in parallel as usual
+	var state cache.EntryState
+	if !f.cached {
+		return m.ProxyFiberRequest(c)
+	} else if state, e = m.cache.IsCached(country, key.UnsafeString()); e != nil || state != cache.ESFresh {
+		return m.ProxyFiberRequest(c)
+	}
+
+	c.Response().Header.Set("X-Alice-Cache", "HIT")
+	return m.respondFromCache(c)
+}
+
+func (m *Proxy) leadFlight(c *fiber.Ctx, fkey string, f *flight) (e error) {
+	defer func() { m.flights.leave(fkey, f, e, e == nil && !m.IsCacheBypass(c)) }()
+	return m.ProxyFiberRequest(c)
+}
@@ -0,0 +1,63 @@
+package proxy
+
+import (
+	"bytes"
+	"strconv"
+
+	"github.com/anilibria/alice/internal/cache"
+	"github.com/gofiber/fiber/v2"
+	"github.com/valyala/fasthttp"
+)
+
+// setValidators sets ETag and Last-Modified headers of the cached entry;
+// every encoded variant has its own strong ETag
+func setValidators(c *fiber.Ctx, info *cache.EntryInfo, enc cache.Encoding) {
+	etag := make([]byte, 0, 24)
+	etag = append(etag, '"')
+	etag = strconv.AppendUint(etag, info.Hash, 16)
+	if enc != cache.EncIdentity {
+		etag = append(etag, '-')
+		etag = append(etag, cache.Enctos[enc]...)
+	}
+	etag = append(etag, '"')
+
+	c.Response().Header.SetBytesV(fiber.HeaderETag, etag)
+	c.Response().Header.SetBytesV(fiber.HeaderLastModified,
+		fasthttp.AppendHTTPDate(nil, info.StoredAt))
+}
+
+// isNotModified checks If-None-Match and If-Modified-Since request headers;
+// If-Modified-Since is ignored when If-None-Match is present (rfc9110 13.1.3)
+func isNotModified(c *fiber.Ctx, info *cache.EntryInfo) bool {
+	if inm := c.Request().Header.Peek(fiber.HeaderIfNoneMatch); len(inm) != 0 {
+		return isETagMatched(inm, c.Response().Header.Peek(fiber.HeaderETag))
+	}
+
+	ims := c.Request().Header.Peek(fiber.HeaderIfModifiedSince)
+	if len(ims) == 0 {
+		return false
+	}
+
+	since, e := fasthttp.ParseHTTPDate(ims)
+	if e != nil {
+		return false
+	}
+
+	return !info.StoredAt.After(since)
+}
+
+func isETagMatched(inm, etag []byte) bool {
+	if bytes.Equal(bytes.TrimSpace(inm), []byte("*")) {
+		return true
+	}
+
+	for _, tag := range bytes.Split(inm, []byte(",")) {
+		tag = bytes.TrimPrefix(bytes.TrimSpace(tag), []byte("W/"))
+
+		if bytes.Equal(tag, etag) {
+			return true
+		}
+	}
+
+	return false
+}
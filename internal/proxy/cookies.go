@@ -0,0 +1,96 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	futils "github.com/gofiber/fiber/v2/utils"
+	"github.com/rs/zerolog"
+	"github.com/urfave/cli/v2"
+	"github.com/valyala/fasthttp"
+)
+
+type CookiePolicyMode uint8
+
+const (
+	CPBypass CookiePolicyMode = iota
+	CPStrip
+)
+
+var Stocp = map[string]CookiePolicyMode{
+	"bypass": CPBypass,
+	"strip":  CPStrip,
+}
+
+// CookiePolicy defines if the upstream response with Set-Cookie headers could be cached;
+// bypass - never cache such responses,
+// strip - drop cookies from the strip list and cache the response if no other cookies are left
+type CookiePolicy struct {
+	mode  CookiePolicyMode
+	strip map[string]bool
+}
+
+func NewCookiePolicy(c *cli.Context) (_ *CookiePolicy, e error) {
+	cp := &CookiePolicy{
+		strip: make(map[string]bool),
+	}
+
+	var ok bool
+	if cp.mode, ok = Stocp[c.String("cache-cookie-policy")]; !ok {
+		e = fmt.Errorf("unknown cookie policy %s", c.String("cache-cookie-policy"))
+		return
+	}
+
+	for _, name := range strings.Split(c.String("cache-cookie-strip-list"), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			cp.strip[name] = true
+		}
+	}
+
+	if cp.mode == CPStrip && len(cp.strip) == 0 {
+		e = errors.New("cache-cookie-strip-list must be defined for the strip cookie policy")
+	}
+
+	return cp, e
+}
+
+// isCacheable reports if the response could be cached after applying the policy;
+// cookies are stripped only if all of them are in the strip list,
+// so cacheable response never contains Set-Cookie headers
+func (m *CookiePolicy) isCacheable(log *zerolog.Logger, rsp *fasthttp.Response) bool {
+	var cookies, strippable int
+	rsp.Header.VisitAllCookie(func(key, _ []byte) {
+		cookies++
+
+		if m.strip[futils.UnsafeString(key)] {
+			strippable++
+		}
+	})
+
+	switch {
+	case cookies == 0:
+		return true
+	case m.mode != CPStrip || strippable != cookies:
+		return false
+	}
+
+	if zerolog.GlobalLevel() < zerolog.InfoLevel {
+		log.Trace().Msgf("%d cookies have been stripped from the cacheable response", cookies)
+	}
+
+	rsp.Header.DelAllCookies()
+	return true
+}
+
+// passCookies copies all upstream Set-Cookie headers as is, with all their attributes;
+// it returns false if there are no cookies in the response
+func passCookies(c *fiber.Ctx, rsp *fasthttp.Response) (passed bool) {
+	rsp.Header.VisitAllCookie(func(_, value []byte) {
+		c.Response().Header.AddBytesKV([]byte(fiber.HeaderSetCookie), value)
+		passed = true
+	})
+
+	return
+}
@@ -0,0 +1,61 @@
+package proxy
+
+import (
+	"bytes"
+
+	"github.com/anilibria/alice/internal/cache"
+	"github.com/gofiber/fiber/v2"
+	futils "github.com/gofiber/fiber/v2/utils"
+)
+
+// negotiateEncoding returns the first of enabled encodings (in order of server preference)
+// accepted by the client; identity is used if nothing is matched
+func (m *Proxy) negotiateEncoding(c *fiber.Ctx) cache.Encoding {
+	encodings := m.cache.Encodings()
+	if len(encodings) == 0 {
+		return cache.EncIdentity
+	}
+
+	// response depends on Accept-Encoding even if identity is selected
+	c.Response().Header.Add(fiber.HeaderVary, fiber.HeaderAcceptEncoding)
+
+	accept := c.Request().Header.Peek(fiber.HeaderAcceptEncoding)
+	if len(accept) == 0 {
+		return cache.EncIdentity
+	}
+
+	for _, enc := range encodings {
+		if isEncodingAccepted(accept, futils.UnsafeBytes(cache.Enctos[enc])) {
+			return enc
+		}
+	}
+
+	return cache.EncIdentity
+}
+
+// Accept-Encoding format - br;q=1.0, gzip;q=0.8, *;q=0.1
+// explicitly listed coding takes precedence over "*" regardless of their order
+func isEncodingAccepted(accept, encoding []byte) bool {
+	var wildcard bool
+	for _, coding := range bytes.Split(accept, []byte(",")) {
+		name, params, _ := bytes.Cut(coding, []byte(";"))
+		name = bytes.TrimSpace(name)
+
+		explicit := bytes.EqualFold(name, encoding)
+		if !explicit && !bytes.Equal(name, []byte("*")) {
+			continue
+		}
+
+		// q=0 means "not acceptable"
+		q := bytes.TrimSpace(params)
+		acceptable := !bytes.HasPrefix(q, []byte("q=")) || len(bytes.Trim(q[2:], "0.")) != 0
+
+		if explicit {
+			return acceptable
+		}
+
+		wildcard = acceptable
+	}
+
+	return wildcard
+}
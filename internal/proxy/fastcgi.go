@@ -0,0 +1,468 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	futils "github.com/gofiber/fiber/v2/utils"
+	"github.com/rs/zerolog"
+	"github.com/urfave/cli/v2"
+	"github.com/valyala/fasthttp"
+)
+
+// https://fastcgi-archives.github.io/FastCGI_Specification.html
+const (
+	fcgiVersion = 1
+
+	fcgiBeginRequest = 1
+	fcgiEndRequest   = 3
+	fcgiParams       = 4
+	fcgiStdin        = 5
+	fcgiStdout       = 6
+	fcgiStderr       = 7
+
+	fcgiRoleResponder = 1
+	fcgiKeepConn      = 1
+
+	fcgiRequestComplete = 0
+
+	fcgiHeaderSize = 8
+	fcgiMaxContent = 65535
+
+	// requests are never multiplexed, so the only one id is used
+	fcgiRequestID = 1
+)
+
+var (
+	errFastCGIProtocol     = errors.New("fastcgi upstream has not completed the request")
+	errFastCGIResponseSize = errors.New("fastcgi upstream response exceeds proxy-fastcgi-max-response-size")
+)
+
+// FastCGIClient talks to PHP-FPM directly (tcp or unix socket) without the nginx hop;
+// connections are kept alive and reused in LIFO order
+type FastCGIClient struct {
+	name string
+
+	network, addr string
+
+	docRoot      string
+	params       [][2]string
+	realIPHeader string
+
+	maxResponseSize int
+
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+	idleTimeout  time.Duration
+	connTimeout  time.Duration
+
+	conns chan struct{}
+
+	mu   sync.Mutex
+	idle []*fcgiConn
+
+	log *zerolog.Logger
+}
+
+type fcgiConn struct {
+	net.Conn
+
+	rd *bufio.Reader
+	wr *bufio.Writer
+
+	createdAt time.Time
+	usedAt    time.Time
+}
+
+// addr format - 10.0.0.1:9000 or unix:/run/php/php-fpm.sock
+func NewFastCGIClient(c *cli.Context, log *zerolog.Logger, addr string) *FastCGIClient {
+	client := &FastCGIClient{
+		name: fmt.Sprintf("Mozilla/5.0 (compatible; %s/%s; +https://anilibria.top/support)",
+			c.App.Name, c.App.Version),
+
+		network: "tcp",
+		addr:    addr,
+
+		docRoot:      strings.TrimRight(c.String("proxy-fastcgi-document-root"), "/"),
+		realIPHeader: c.String("http-realip-header"),
+
+		maxResponseSize: c.Int("proxy-fastcgi-max-response-size"),
+
+		readTimeout:  c.Duration("proxy-read-timeout"),
+		writeTimeout: c.Duration("proxy-write-timeout"),
+		idleTimeout:  c.Duration("proxy-idle-timeout"),
+		connTimeout:  c.Duration("proxy-conn-timeout"),
+
+		log: log,
+	}
+
+	// zero capacity blocks every request, so the fasthttp default is used like HostClient does
+	maxConns := c.Int("proxy-max-conns-per-host")
+	if maxConns <= 0 {
+		maxConns = fasthttp.DefaultMaxConnsPerHost
+	}
+
+	client.conns = make(chan struct{}, maxConns)
+
+	if strings.HasPrefix(addr, "unix:") {
+		client.network, client.addr = "unix", strings.TrimPrefix(addr, "unix:")
+	}
+
+	for _, param := range strings.Split(c.String("proxy-fastcgi-params"), ",") {
+		if param = strings.TrimSpace(param); param == "" {
+			continue
+		}
+
+		name, value, _ := strings.Cut(param, "=")
+		client.params = append(client.params, [2]string{strings.TrimSpace(name), strings.TrimSpace(value)})
+	}
+
+	return client
+}
+
+func (m *FastCGIClient) UserAgent() string {
+	return m.name
+}
+
+func (m *FastCGIClient) Do(req *fasthttp.Request, rsp *fasthttp.Response) error {
+	return m.DoTimeout(req, rsp, m.readTimeout)
+}
+
+func (m *FastCGIClient) DoTimeout(req *fasthttp.Request, rsp *fasthttp.Response, timeout time.Duration) (e error) {
+	deadline := time.Now().Add(timeout)
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case m.conns <- struct{}{}:
+		defer func() { <-m.conns }()
+	case <-timer.C:
+		return fasthttp.ErrNoFreeConns
+	}
+
+	var conn *fcgiConn
+	if conn, e = m.acquireConn(deadline); e != nil {
+		return
+	}
+
+	if e = m.roundTrip(conn, req, rsp, deadline); e != nil {
+		conn.Close()
+		return
+	}
+
+	m.releaseConn(conn)
+	return
+}
+
+func (m *FastCGIClient) acquireConn(deadline time.Time) (_ *fcgiConn, e error) {
+	now := time.Now()
+
+	m.mu.Lock()
+	for len(m.idle) != 0 {
+		conn := m.idle[len(m.idle)-1]
+		m.idle = m.idle[:len(m.idle)-1]
+
+		if m.isExpired(conn, now) {
+			conn.Close()
+			continue
+		}
+
+		m.mu.Unlock()
+		return conn, e
+	}
+	m.mu.Unlock()
+
+	var conn net.Conn
+	if conn, e = (&net.Dialer{Deadline: deadline}).Dial(m.network, m.addr); e != nil {
+		return
+	}
+
+	return &fcgiConn{
+		Conn: conn,
+		rd:   bufio.NewReader(conn),
+		wr:   bufio.NewWriter(conn),
+
+		createdAt: now,
+	}, e
+}
+
+func (m *FastCGIClient) releaseConn(conn *fcgiConn) {
+	now := time.Now()
+	conn.usedAt = now
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// idle connections are ordered by the last usage, so the oldest ones are in the head
+	var expired int
+	for ; expired < len(m.idle) && m.isExpired(m.idle[expired], now); expired++ {
+		m.idle[expired].Close()
+	}
+
+	m.idle = append(m.idle[expired:], conn)
+}
+
+func (m *FastCGIClient) isExpired(conn *fcgiConn, now time.Time) bool {
+	return (m.idleTimeout > 0 && now.Sub(conn.usedAt) > m.idleTimeout) ||
+		(m.connTimeout > 0 && now.Sub(conn.createdAt) > m.connTimeout)
+}
+
+func (m *FastCGIClient) roundTrip(conn *fcgiConn, req *fasthttp.Request, rsp *fasthttp.Response,
+	deadline time.Time) (e error) {
+	if e = conn.SetWriteDeadline(m.writeDeadline(deadline)); e != nil {
+		return
+	}
+
+	if e = m.writeRequest(conn.wr, req); e != nil {
+		return
+	}
+
+	if e = conn.SetReadDeadline(deadline); e != nil {
+		return
+	}
+
+	var stdout []byte
+	if stdout, e = m.readResponse(conn.rd); e != nil {
+		return
+	}
+
+	return parseCGIResponse(stdout, rsp)
+}
+
+func (m *FastCGIClient) writeDeadline(deadline time.Time) time.Time {
+	if m.writeTimeout <= 0 {
+		return deadline
+	}
+
+	if wd := time.Now().Add(m.writeTimeout); wd.Before(deadline) {
+		return wd
+	}
+
+	return deadline
+}
+
+func (m *FastCGIClient) writeRequest(wr *bufio.Writer, req *fasthttp.Request) (e error) {
+	begin := [8]byte{0, fcgiRoleResponder, fcgiKeepConn}
+	if e = writeRecord(wr, fcgiBeginRequest, begin[:]); e != nil {
+		return
+	}
+
+	if e = writeStream(wr, fcgiParams, m.appendParams(nil, req)); e != nil {
+		return
+	}
+
+	if e = writeStream(wr, fcgiStdin, req.Body()); e != nil {
+		return
+	}
+
+	return wr.Flush()
+}
+
+// appendParams maps the request to CGI/1.1 meta-variables like nginx fastcgi_params does
+func (m *FastCGIClient) appendParams(buf []byte, req *fasthttp.Request) []byte {
+	uri := req.URI()
+	path := futils.UnsafeString(uri.Path())
+
+	buf = appendParam(buf, "GATEWAY_INTERFACE", "CGI/1.1")
+	buf = appendParam(buf, "SERVER_SOFTWARE", "alice")
+	buf = appendParam(buf, "SERVER_PROTOCOL", "HTTP/1.1")
+	buf = appendParam(buf, "SERVER_NAME", futils.UnsafeString(req.Header.Host()))
+
+	buf = appendParam(buf, "REQUEST_METHOD", futils.UnsafeString(req.Header.Method()))
+	buf = appendParam(buf, "REQUEST_URI", futils.UnsafeString(req.RequestURI()))
+	buf = appendParam(buf, "QUERY_STRING", futils.UnsafeString(uri.QueryString()))
+
+	buf = appendParam(buf, "DOCUMENT_ROOT", m.docRoot)
+	buf = appendParam(buf, "DOCUMENT_URI", path)
+	buf = appendParam(buf, "SCRIPT_NAME", path)
+	buf = appendParam(buf, "SCRIPT_FILENAME", m.docRoot+path)
+
+	buf = appendParam(buf, "CONTENT_TYPE", futils.UnsafeString(req.Header.ContentType()))
+	buf = appendParam(buf, "CONTENT_LENGTH", strconv.Itoa(len(req.Body())))
+
+	if m.realIPHeader != "" {
+		if ip := req.Header.Peek(m.realIPHeader); len(ip) != 0 {
+			buf = appendParam(buf, "REMOTE_ADDR", futils.UnsafeString(ip))
+		}
+	}
+
+	req.Header.VisitAll(func(key, value []byte) {
+		if bytes.EqualFold(key, []byte(fasthttp.HeaderContentType)) ||
+			bytes.EqualFold(key, []byte(fasthttp.HeaderContentLength)) {
+			return
+		}
+
+		// HTTP_PROXY is used by PHP http clients (httpoxy) and headers with underscores
+		// could spoof the other ones (X_Real_IP and X-Real-IP), so they are dropped like nginx does
+		if bytes.EqualFold(key, []byte("Proxy")) || bytes.IndexByte(key, '_') != -1 {
+			return
+		}
+
+		name := make([]byte, 0, len(key)+5)
+		name = append(name, "HTTP_"...)
+		for _, ch := range key {
+			switch {
+			case ch == '-':
+				ch = '_'
+			case ch >= 'a' && ch <= 'z':
+				ch -= 'a' - 'A'
+			}
+			name = append(name, ch)
+		}
+
+		buf = appendParam(buf, futils.UnsafeString(name), futils.UnsafeString(value))
+	})
+
+	for _, param := range m.params {
+		buf = appendParam(buf, param[0], param[1])
+	}
+
+	return buf
+}
+
+func appendParam(buf []byte, name, value string) []byte {
+	buf = appendParamLength(buf, len(name))
+	buf = appendParamLength(buf, len(value))
+	buf = append(buf, name...)
+	return append(buf, value...)
+}
+
+func appendParamLength(buf []byte, n int) []byte {
+	if n < 128 {
+		return append(buf, byte(n))
+	}
+
+	return binary.BigEndian.AppendUint32(buf, uint32(n)|1<<31)
+}
+
+// writeStream splits payload into records and closes the stream with an empty one
+func writeStream(wr *bufio.Writer, rtype byte, payload []byte) (e error) {
+	for len(payload) != 0 {
+		chunk := payload
+		if len(chunk) > fcgiMaxContent {
+			chunk = chunk[:fcgiMaxContent]
+		}
+
+		if e = writeRecord(wr, rtype, chunk); e != nil {
+			return
+		}
+
+		payload = payload[len(chunk):]
+	}
+
+	return writeRecord(wr, rtype, nil)
+}
+
+func writeRecord(wr *bufio.Writer, rtype byte, content []byte) (e error) {
+	var header [fcgiHeaderSize]byte
+	header[0], header[1] = fcgiVersion, rtype
+	binary.BigEndian.PutUint16(header[2:4], fcgiRequestID)
+	binary.BigEndian.PutUint16(header[4:6], uint16(len(content)))
+
+	if _, e = wr.Write(header[:]); e != nil {
+		return
+	}
+
+	_, e = wr.Write(content)
+	return
+}
+
+func (m *FastCGIClient) readResponse(rd *bufio.Reader) (stdout []byte, e error) {
+	var header [fcgiHeaderSize]byte
+	var stderr []byte
+
+	for {
+		if _, e = io.ReadFull(rd, header[:]); e != nil {
+			return
+		}
+
+		content := make([]byte, binary.BigEndian.Uint16(header[4:6]))
+		if _, e = io.ReadFull(rd, content); e != nil {
+			return
+		}
+
+		if _, e = rd.Discard(int(header[6])); e != nil {
+			return
+		}
+
+		if binary.BigEndian.Uint16(header[2:4]) != fcgiRequestID {
+			continue
+		}
+
+		if m.maxResponseSize > 0 && len(stdout)+len(stderr)+len(content) > m.maxResponseSize {
+			e = errFastCGIResponseSize
+			return
+		}
+
+		switch header[1] {
+		case fcgiStdout:
+			stdout = append(stdout, content...)
+		case fcgiStderr:
+			stderr = append(stderr, content...)
+		case fcgiEndRequest:
+			if len(stderr) != 0 {
+				m.log.Warn().Msgf("fastcgi upstream %s stderr - %s", m.addr, bytes.TrimSpace(stderr))
+			}
+
+			if len(content) < 5 || content[4] != fcgiRequestComplete {
+				e = errFastCGIProtocol
+			}
+
+			return
+		}
+	}
+}
+
+// parseCGIResponse fills the response with CGI headers and body;
+// Status header is used for the response code, 200 by default
+func parseCGIResponse(stdout []byte, rsp *fasthttp.Response) (e error) {
+	headers, body, ok := bytes.Cut(stdout, []byte("\r\n\r\n"))
+	if !ok {
+		if headers, body, ok = bytes.Cut(stdout, []byte("\n\n")); !ok {
+			return errors.New("fastcgi upstream respond without headers")
+		}
+	}
+
+	status := fasthttp.StatusOK
+	for _, line := range bytes.Split(headers, []byte("\n")) {
+		key, value, found := bytes.Cut(bytes.TrimRight(line, "\r"), []byte(":"))
+		if !found {
+			continue
+		}
+
+		key, value = bytes.TrimSpace(key), bytes.TrimSpace(value)
+
+		switch {
+		case bytes.EqualFold(key, []byte("Status")):
+			if len(value) < 3 {
+				return fmt.Errorf("fastcgi upstream respond with invalid status %s", value)
+			}
+
+			if status, e = strconv.Atoi(string(value[:3])); e != nil {
+				return
+			}
+		case bytes.EqualFold(key, []byte(fasthttp.HeaderContentLength)):
+			// body length is known from the stdout stream
+		case bytes.EqualFold(key, []byte(fasthttp.HeaderLocation)) && status == fasthttp.StatusOK:
+			rsp.Header.AddBytesKV(key, value)
+			status = fasthttp.StatusFound
+		default:
+			rsp.Header.AddBytesKV(key, value)
+		}
+	}
+
+	rsp.SetStatusCode(status)
+	rsp.SetBody(body)
+
+	return
+}
@@ -0,0 +1,389 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/urfave/cli/v2"
+	"github.com/valyala/fasthttp"
+)
+
+type testFastCGIRecord struct {
+	rtype   byte
+	content []byte
+}
+
+type testFastCGIRequest struct {
+	params map[string]string
+	stdin  []byte
+}
+
+// testFastCGIEnd completes the request with FCGI_REQUEST_COMPLETE
+var testFastCGIEnd = testFastCGIRecord{fcgiEndRequest, make([]byte, 8)}
+
+// newTestFastCGIServer starts the in-process responder which passes parsed requests to the channel
+// and answers with the given records; connections are kept alive like PHP-FPM does
+func newTestFastCGIServer(t *testing.T, requests chan<- *testFastCGIRequest,
+	records ...testFastCGIRecord) string {
+	t.Helper()
+
+	ln, e := net.Listen("tcp", "127.0.0.1:0")
+	if e != nil {
+		t.Fatal(e)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	var rsp []byte
+	for _, record := range records {
+		rsp = appendTestFastCGIRecord(rsp, record.rtype, record.content)
+	}
+
+	go func() {
+		for {
+			conn, e := ln.Accept()
+			if e != nil {
+				return
+			}
+
+			go serveTestFastCGI(conn, requests, rsp)
+		}
+	}()
+
+	return ln.Addr().String()
+}
+
+func serveTestFastCGI(conn net.Conn, requests chan<- *testFastCGIRequest, rsp []byte) {
+	defer conn.Close()
+	rd := bufio.NewReader(conn)
+
+	for {
+		var params, stdin []byte
+		var paramsDone, stdinDone bool
+
+		for !paramsDone || !stdinDone {
+			var header [fcgiHeaderSize]byte
+			if _, e := io.ReadFull(rd, header[:]); e != nil {
+				return
+			}
+
+			content := make([]byte, binary.BigEndian.Uint16(header[4:6]))
+			if _, e := io.ReadFull(rd, content); e != nil {
+				return
+			} else if _, e = rd.Discard(int(header[6])); e != nil {
+				return
+			}
+
+			switch header[1] {
+			case fcgiParams:
+				params, paramsDone = append(params, content...), len(content) == 0
+			case fcgiStdin:
+				stdin, stdinDone = append(stdin, content...), len(content) == 0
+			}
+		}
+
+		if requests != nil {
+			requests <- &testFastCGIRequest{params: parseTestFastCGIParams(params), stdin: stdin}
+		}
+
+		if _, e := conn.Write(rsp); e != nil {
+			return
+		}
+	}
+}
+
+// appendTestFastCGIRecord pads the content to 8 bytes like PHP-FPM does
+func appendTestFastCGIRecord(buf []byte, rtype byte, content []byte) []byte {
+	padding := (8 - len(content)%8) % 8
+
+	buf = append(buf, fcgiVersion, rtype)
+	buf = binary.BigEndian.AppendUint16(buf, fcgiRequestID)
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(content)))
+	buf = append(buf, byte(padding), 0)
+	buf = append(buf, content...)
+
+	return append(buf, make([]byte, padding)...)
+}
+
+func parseTestFastCGIParams(buf []byte) map[string]string {
+	params := make(map[string]string)
+
+	length := func() (n int) {
+		if buf[0] < 128 {
+			n, buf = int(buf[0]), buf[1:]
+			return
+		}
+
+		n, buf = int(binary.BigEndian.Uint32(buf)&^(1<<31)), buf[4:]
+		return
+	}
+
+	for len(buf) != 0 {
+		nlen := length()
+		vlen := length()
+
+		params[string(buf[:nlen])] = string(buf[nlen : nlen+vlen])
+		buf = buf[nlen+vlen:]
+	}
+
+	return params
+}
+
+func newTestFastCGIClient(t *testing.T, addr string, flags map[string]string, log *zerolog.Logger) *FastCGIClient {
+	t.Helper()
+
+	set := flag.NewFlagSet("alice", flag.ContinueOnError)
+	set.String("proxy-fastcgi-document-root", "/var/www/api", "")
+	set.String("proxy-fastcgi-params", "", "")
+	set.String("proxy-fastcgi-max-response-size", "0", "")
+	set.String("http-realip-header", "X-Real-Ip", "")
+	set.String("proxy-max-conns-per-host", "4", "")
+	set.String("proxy-read-timeout", "5s", "")
+	set.String("proxy-write-timeout", "5s", "")
+	set.String("proxy-idle-timeout", "1m", "")
+	set.String("proxy-conn-timeout", "0s", "")
+
+	for name, value := range flags {
+		if e := set.Set(name, value); e != nil {
+			t.Fatal(e)
+		}
+	}
+
+	if log == nil {
+		nop := zerolog.Nop()
+		log = &nop
+	}
+
+	return NewFastCGIClient(cli.NewContext(&cli.App{Name: "alice", Version: "test"}, set, nil), log, addr)
+}
+
+func doTestFastCGIRequest(client *FastCGIClient, body []byte, headers ...string) (*fasthttp.Response, error) {
+	req, rsp := fasthttp.AcquireRequest(), fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseRequest(req)
+
+	req.Header.SetMethod(fasthttp.MethodPost)
+	req.Header.SetContentType("application/x-www-form-urlencoded")
+	req.SetRequestURI("http://api.example.org/public/api/index.php?v=1")
+	req.SetBody(body)
+
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+
+	return rsp, client.DoTimeout(req, rsp, 5*time.Second)
+}
+
+func TestFastCGIRequestParams(t *testing.T) {
+	requests := make(chan *testFastCGIRequest, 1)
+	addr := newTestFastCGIServer(t, requests,
+		testFastCGIRecord{fcgiStdout, []byte("Content-Type: application/json\r\n\r\n{}")}, testFastCGIEnd)
+
+	client := newTestFastCGIClient(t, addr, map[string]string{
+		"proxy-fastcgi-params": "HTTPS=on, APP_ENV=test",
+	}, nil)
+
+	// stdin larger than a single record must be split
+	body := bytes.Repeat([]byte("a"), fcgiMaxContent*2+1)
+
+	if _, e := doTestFastCGIRequest(client, body,
+		"X-Real-Ip", "10.0.0.1", "X-Custom-Header", "value", "Proxy", "http://evil.example.org",
+		"X_Real_IP", "10.0.0.2"); e != nil {
+		t.Fatal(e)
+	}
+
+	req := <-requests
+	if !bytes.Equal(req.stdin, body) {
+		t.Fatalf("stdin of %d bytes is expected, got %d", len(body), len(req.stdin))
+	}
+
+	for name, value := range map[string]string{
+		"REQUEST_METHOD":       "POST",
+		"REQUEST_URI":          "/public/api/index.php?v=1",
+		"QUERY_STRING":         "v=1",
+		"SCRIPT_FILENAME":      "/var/www/api/public/api/index.php",
+		"CONTENT_TYPE":         "application/x-www-form-urlencoded",
+		"CONTENT_LENGTH":       "131071",
+		"REMOTE_ADDR":          "10.0.0.1",
+		"HTTP_X_CUSTOM_HEADER": "value",
+		"HTTPS":                "on",
+		"APP_ENV":              "test",
+	} {
+		if req.params[name] != value {
+			t.Errorf("param %s must be %q, got %q", name, value, req.params[name])
+		}
+	}
+
+	if value, ok := req.params["HTTP_PROXY"]; ok {
+		t.Errorf("Proxy header must be dropped, got HTTP_PROXY %q", value)
+	} else if value = req.params["HTTP_X_REAL_IP"]; value != "10.0.0.1" {
+		t.Errorf("headers with underscores must not spoof the other ones, got HTTP_X_REAL_IP %q", value)
+	}
+}
+
+func TestFastCGIResponse(t *testing.T) {
+	tests := []struct {
+		name    string
+		records []testFastCGIRecord
+
+		status int
+		body   string
+		header [2]string
+		err    bool
+	}{
+		{
+			name: "stdout split across records",
+			records: []testFastCGIRecord{
+				{fcgiStdout, []byte("Content-Type: application/json\r\nX-Powered-By: PHP")},
+				{fcgiStdout, []byte("\r\nContent-Length: 100\r\n\r\n{\"status\":")},
+				{fcgiStdout, []byte("true}")},
+				testFastCGIEnd,
+			},
+			status: fasthttp.StatusOK,
+			body:   `{"status":true}`,
+			header: [2]string{"X-Powered-By", "PHP"},
+		},
+		{
+			name: "stderr is not a part of the body",
+			records: []testFastCGIRecord{
+				{fcgiStdout, []byte("Content-Type: text/plain\n\nok")},
+				{fcgiStderr, []byte("PHP Warning: something went wrong")},
+				{fcgiStdout, []byte("!")},
+				testFastCGIEnd,
+			},
+			status: fasthttp.StatusOK,
+			body:   "ok!",
+		},
+		{
+			name: "status header",
+			records: []testFastCGIRecord{
+				{fcgiStdout, []byte("Status: 404 Not Found\r\nContent-Type: text/plain\r\n\r\nnot found")},
+				testFastCGIEnd,
+			},
+			status: fasthttp.StatusNotFound,
+			body:   "not found",
+		},
+		{
+			name: "location without status",
+			records: []testFastCGIRecord{
+				{fcgiStdout, []byte("Location: /public/login.php\r\n\r\n")},
+				testFastCGIEnd,
+			},
+			status: fasthttp.StatusFound,
+			header: [2]string{"Location", "/public/login.php"},
+		},
+		{
+			name: "invalid status header",
+			records: []testFastCGIRecord{
+				{fcgiStdout, []byte("Status: 2\r\n\r\n")},
+				testFastCGIEnd,
+			},
+			err: true,
+		},
+		{
+			name: "response without headers",
+			records: []testFastCGIRecord{
+				{fcgiStdout, []byte("{}")},
+				testFastCGIEnd,
+			},
+			err: true,
+		},
+		{
+			name: "request is not completed",
+			records: []testFastCGIRecord{
+				{fcgiStdout, []byte("Content-Type: text/plain\r\n\r\nok")},
+				{fcgiEndRequest, []byte{0, 0, 0, 0, 1, 0, 0, 0}},
+			},
+			err: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var logs bytes.Buffer
+			log := zerolog.New(&logs)
+
+			client := newTestFastCGIClient(t, newTestFastCGIServer(t, nil, tt.records...), nil, &log)
+
+			rsp, e := doTestFastCGIRequest(client, []byte("query=config"))
+			defer fasthttp.ReleaseResponse(rsp)
+
+			if tt.err {
+				if e == nil {
+					t.Fatal("request must fail")
+				}
+				return
+			} else if e != nil {
+				t.Fatal(e)
+			}
+
+			if rsp.StatusCode() != tt.status {
+				t.Errorf("status %d is expected, got %d", tt.status, rsp.StatusCode())
+			}
+
+			if string(rsp.Body()) != tt.body {
+				t.Errorf("body %q is expected, got %q", tt.body, rsp.Body())
+			} else if cl := rsp.Header.ContentLength(); cl > 0 && cl != len(tt.body) {
+				t.Errorf("upstream content length must be ignored, got %d", cl)
+			}
+
+			if tt.header[0] != "" && string(rsp.Header.Peek(tt.header[0])) != tt.header[1] {
+				t.Errorf("header %s must be %q, got %q", tt.header[0], tt.header[1], rsp.Header.Peek(tt.header[0]))
+			}
+
+			for _, record := range tt.records {
+				if record.rtype == fcgiStderr && !strings.Contains(logs.String(), string(record.content)) {
+					t.Errorf("stderr must be logged, got %q", logs.String())
+				}
+			}
+		})
+	}
+}
+
+func TestFastCGIResponseSize(t *testing.T) {
+	addr := newTestFastCGIServer(t, nil,
+		testFastCGIRecord{fcgiStdout, []byte("Content-Type: text/plain\r\n\r\n")},
+		testFastCGIRecord{fcgiStdout, bytes.Repeat([]byte("a"), 512)},
+		testFastCGIRecord{fcgiStderr, bytes.Repeat([]byte("e"), 512)},
+		testFastCGIEnd)
+
+	client := newTestFastCGIClient(t, addr, map[string]string{"proxy-fastcgi-max-response-size": "1024"}, nil)
+	if _, e := doTestFastCGIRequest(client, nil); !errors.Is(e, errFastCGIResponseSize) {
+		t.Fatalf("response with stderr over proxy-fastcgi-max-response-size must be rejected, got %v", e)
+	}
+
+	client = newTestFastCGIClient(t, addr, map[string]string{"proxy-fastcgi-max-response-size": "2048"}, nil)
+	if _, e := doTestFastCGIRequest(client, nil); e != nil {
+		t.Fatalf("response within proxy-fastcgi-max-response-size must be accepted - %v", e)
+	}
+}
+
+func TestFastCGIMaxConns(t *testing.T) {
+	addr := newTestFastCGIServer(t, nil,
+		testFastCGIRecord{fcgiStdout, []byte("Content-Type: text/plain\r\n\r\nok")}, testFastCGIEnd)
+
+	client := newTestFastCGIClient(t, addr, map[string]string{"proxy-max-conns-per-host": "0"}, nil)
+	if cap(client.conns) != fasthttp.DefaultMaxConnsPerHost {
+		t.Fatalf("%d conns are expected for zero proxy-max-conns-per-host, got %d",
+			fasthttp.DefaultMaxConnsPerHost, cap(client.conns))
+	}
+
+	// the connection is released after every request, so the only one is enough
+	client = newTestFastCGIClient(t, addr, map[string]string{"proxy-max-conns-per-host": "1"}, nil)
+	for i := 0; i < 3; i++ {
+		if _, e := doTestFastCGIRequest(client, nil); e != nil {
+			t.Fatal(e)
+		}
+	}
+
+	if len(client.idle) != 1 {
+		t.Fatalf("the only one kept alive connection is expected, got %d", len(client.idle))
+	}
+}
@@ -2,8 +2,10 @@ package proxy
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 
+	"github.com/anilibria/alice/internal/anilibria"
 	"github.com/gofiber/fiber/v2"
 )
 
@@ -30,7 +32,11 @@ func (m *Proxy) HandleProxyToCache(c *fiber.Ctx) (e error) {
 }
 
 func (m *Proxy) HandleProxyToDst(c *fiber.Ctx) (e error) {
-	if e = m.ProxyFiberRequest(c); e != nil {
+	if e = m.proxyCoalescedRequest(c); e != nil {
+		if m.respondStaleOnError(c, e) {
+			return nil
+		}
+
 		return fiber.NewError(fiber.StatusServiceUnavailable, e.Error())
 	}
 
@@ -44,20 +50,33 @@ func (m *Proxy) HandleRandomRelease(c *fiber.Ctx) (e error) {
 		return fiber.NewError(fiber.StatusServiceUnavailable, "BUG! randomizer is not initialized")
 	}
 
-	var release string
-	if release = m.randomizer.Randomize(); release == "" {
+	// filters could be sent in the body or in the query string
+	args := c.Request().PostArgs()
+	if args.Len() == 0 {
+		args = c.Request().URI().QueryArgs()
+	}
+
+	filter, e := anilibria.NewReleaseFilter(args)
+	if e != nil {
+		return fiber.NewError(fiber.StatusBadRequest, e.Error())
+	}
+
+	release, e := m.randomizer.Randomize(m.countryByRemoteIP(c), filter)
+	if errors.Is(e, anilibria.ErrNoMatches) {
+		return fiber.NewError(fiber.StatusNotFound, e.Error())
+	} else if e != nil {
 		return fiber.NewError(fiber.StatusServiceUnavailable,
 			"an error occurred in randomizer, maybe it's not ready yet")
 	}
 
 	c.Response().Header.Set("X-Alice-Cache", "HIT")
 
-	if bytes.Equal(c.Request().PostArgs().Peek("js"), []byte("1")) {
-		fmt.Fprintln(c, release)
+	if bytes.Equal(args.Peek("js"), []byte("1")) {
+		fmt.Fprintln(c, release.Code)
 		return respondPlainWithStatus(c, fiber.StatusOK)
 	}
 
-	c.Response().Header.Set(fiber.HeaderLocation, "/release/"+release+".html")
+	c.Response().Header.Set(fiber.HeaderLocation, "/release/"+release.Code+".html")
 	return respondPlainWithStatus(c, fiber.StatusFound)
 }
 
@@ -129,6 +148,19 @@ func (m *Proxy) HandleCachePurge(c *fiber.Ctx) (e error) {
 	return respondPlainWithStatus(c, fiber.StatusAccepted)
 }
 
+func (m *Proxy) HandleUpstreamStats(c *fiber.Ctx) (_ error) {
+	fmt.Fprintln(c, m.ApiUpstreams())
+	return respondPlainWithStatus(c, fiber.StatusOK)
+}
+
+func (m *Proxy) HandleWhitelistsReload(c *fiber.Ctx) (e error) {
+	if e = m.ReloadWhitelists(); e != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, e.Error())
+	}
+
+	return respondPlainWithStatus(c, fiber.StatusOK)
+}
+
 func (m *Proxy) HandleCachePurgeAll(c *fiber.Ctx) (e error) {
 	if e = m.cache.ApiPurgeAll(); e != nil {
 		return fiber.NewError(fiber.StatusInternalServerError, e.Error())
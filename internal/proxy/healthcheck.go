@@ -0,0 +1,149 @@
+package proxy
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/anilibria/alice/internal/utils"
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/valyala/fasthttp"
+)
+
+type HealthCheckConfig struct {
+	enabled bool
+
+	path, query []byte
+
+	interval time.Duration
+	timeout  time.Duration
+}
+
+func (m *Proxy) loop() {
+	m.log.Debug().Msg("initiate upstream health check loop...")
+	defer m.log.Debug().Msg("upstream health check loop has been closed")
+
+	ticker := time.NewTicker(m.config.healthcheck.interval)
+	defer ticker.Stop()
+
+	m.checkUpstreams()
+
+LOOP:
+	for {
+		select {
+		case <-m.done():
+			m.log.Info().Msg("internal abort() has been caught; initiate application closing...")
+			break LOOP
+		case <-ticker.C:
+			m.checkUpstreams()
+		}
+	}
+}
+
+func (m *Proxy) checkUpstreams() {
+	var wg sync.WaitGroup
+
+	for _, upstream := range m.upstreams {
+		wg.Add(1)
+
+		go func(upstream *Upstream) {
+			defer wg.Done()
+
+			prev := upstream.State()
+
+			e := m.checkUpstream(upstream)
+			upstream.circuit.healthy(e == nil)
+
+			upstream.checkedAt.Store(time.Now().Unix())
+			if e != nil {
+				upstream.checkErr.Store(e.Error())
+				m.log.Warn().Msgf("upstream %s health check failed - %s", upstream.Addr(), e.Error())
+			} else {
+				upstream.checkErr.Store("")
+			}
+
+			if state := upstream.State(); state != prev {
+				m.log.Info().Msgf("upstream %s circuit changed its state from %s to %s",
+					upstream.Addr(), CStos[prev], CStos[state])
+			}
+		}(upstream)
+	}
+
+	wg.Wait()
+}
+
+func (m *Proxy) checkUpstream(upstream *Upstream) (e error) {
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+
+	rsp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(rsp)
+
+	req.Header.SetMethod(fasthttp.MethodPost)
+	req.Header.SetContentType("application/x-www-form-urlencoded")
+	req.Header.SetUserAgent(upstream.client.UserAgent())
+	req.SetRequestURIBytes(m.config.healthcheck.path)
+	req.SetBodyRaw(m.config.healthcheck.query)
+
+	req.Header.SetHost(m.config.dstHost)
+	req.UseHostHeader = true
+
+	if e = upstream.client.DoTimeout(req, rsp, m.config.healthcheck.timeout); e != nil {
+		return
+	}
+
+	if status := rsp.StatusCode(); status != fasthttp.StatusOK {
+		return fmt.Errorf("upstream respond with status %d", status)
+	}
+
+	var apirsp *utils.ApiResponseWOData
+	if apirsp, e = utils.UnmarshalApiResponse(rsp.Body()); e != nil {
+		return
+	}
+	defer utils.ReleaseApiResponseWOData(apirsp)
+
+	if !apirsp.Status {
+		return errors.New("upstream respond with false api status")
+	}
+
+	return
+}
+
+func (m *Proxy) ApiUpstreams() io.Reader {
+	tb := table.NewWriter()
+	defer tb.Render()
+
+	buf := bytes.NewBuffer(nil)
+
+	tb.SetOutputMirror(buf)
+	tb.AppendHeader(table.Row{
+		"upstream", "weight", "circuit", "active", "requests", "failures", "last check", "last check error",
+	})
+
+	for _, upstream := range m.upstreams {
+		var checked string
+		if ts := upstream.checkedAt.Load(); ts != 0 {
+			checked = time.Unix(ts, 0).Format(time.RFC3339)
+		}
+
+		checkErr, _ := upstream.checkErr.Load().(string)
+
+		tb.AppendRow([]interface{}{
+			upstream.Addr(),
+			upstream.weight,
+			CStos[upstream.State()],
+			upstream.Active(),
+			upstream.Requests(),
+			upstream.Failures(),
+			checked,
+			checkErr,
+		})
+	}
+
+	tb.Style().Options.SeparateRows = true
+
+	return buf
+}
@@ -2,13 +2,15 @@ package proxy
 
 import (
 	"bytes"
+	"errors"
 
+	"github.com/anilibria/alice/internal/anilibria"
 	"github.com/anilibria/alice/internal/utils"
 	"github.com/gofiber/fiber/v2"
 )
 
 func (m *Proxy) MiddlewareValidation(c *fiber.Ctx) (e error) {
-	v := AcquireValidator(c, c.Request().Header.ContentType())
+	v := AcquireValidator(c, c.Request().Header.ContentType(), m.config.validator)
 	defer ReleaseValidator(v)
 
 	if e = v.ValidateRequest(); e != nil {
@@ -19,15 +21,25 @@ func (m *Proxy) MiddlewareValidation(c *fiber.Ctx) (e error) {
 	c.Response().Header.Set("X-Alice-Cache", "MISS")
 
 	// hijack all query=random_release queries
-	if v.IsQueryEqual([]byte("random_release")) {
-		if m.randomizer != nil {
-			if release := m.randomizer.Randomize(); release != "" {
-				if e = utils.RespondWithRandomRelease(release, c); e == nil {
-					c.Response().Header.Set("X-Alice-Cache", "HIT")
-					return respondPlainWithStatus(c, fiber.StatusOK)
-				}
-				rlog(c).Error().Msg("could not respond on random release query - " + e.Error())
+	if v.IsQueryEqual([]byte("random_release")) && m.randomizer != nil {
+		var filter *anilibria.ReleaseFilter
+		if filter, e = anilibria.NewReleaseFilter(requestArgs(c)); e != nil {
+			return fiber.NewError(fiber.StatusBadRequest, e.Error())
+		}
+
+		release, err := m.randomizer.Randomize(m.countryByRemoteIP(c), filter)
+		switch {
+		case err == nil:
+			if e = utils.RespondWithRandomRelease(release.Payload, c); e == nil {
+				c.Response().Header.Set("X-Alice-Cache", "HIT")
+				return respondPlainWithStatus(c, fiber.StatusOK)
 			}
+			rlog(c).Error().Msg("could not respond on random release query - " + e.Error())
+		case errors.Is(err, anilibria.ErrNoMatches):
+			return fiber.NewError(fiber.StatusNotFound, err.Error())
+		case !filter.IsEmpty():
+			// upstream knows nothing about filters, so it could not be used as a fallback
+			return fiber.NewError(fiber.StatusServiceUnavailable, err.Error())
 		}
 	}
 
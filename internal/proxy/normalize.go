@@ -0,0 +1,114 @@
+package proxy
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	futils "github.com/gofiber/fiber/v2/utils"
+	"github.com/valyala/fasthttp"
+)
+
+type KeyRuleAction uint8
+
+const (
+	KRDrop KeyRuleAction = iota
+	KRDefault
+	KRLower
+	KRTrim
+)
+
+var Stokr = map[string]KeyRuleAction{
+	"drop":    KRDrop,
+	"default": KRDefault,
+	"lower":   KRLower,
+	"trim":    KRTrim,
+}
+
+// KeyNormalization contains rules for args of the cache key;
+// rules never change args which are sent to the upstream
+type KeyNormalization struct {
+	drop     map[string]bool
+	defaults map[string]string
+	lower    map[string]bool
+	trim     map[string]bool
+}
+
+// cache-key-normalize format - drop:csrf,drop:deviceId,default:page=1,trim:search,lower:search
+func NewKeyNormalization(policy string) (_ *KeyNormalization, e error) {
+	kn := &KeyNormalization{
+		drop:     make(map[string]bool),
+		defaults: make(map[string]string),
+		lower:    make(map[string]bool),
+		trim:     make(map[string]bool),
+	}
+
+	for _, raw := range strings.Split(policy, ",") {
+		if raw = strings.TrimSpace(raw); raw == "" {
+			continue
+		}
+
+		name, arg, _ := strings.Cut(raw, ":")
+		action, ok := Stokr[name]
+		if !ok {
+			e = fmt.Errorf("key normalization rule %s has unknown action %s", raw, name)
+			return
+		}
+
+		var value string
+		if action == KRDefault {
+			if arg, value, ok = strings.Cut(arg, "="); !ok {
+				e = fmt.Errorf("key normalization rule %s has no default value", raw)
+				return
+			}
+		}
+
+		if arg == "" || arg == "query" {
+			e = fmt.Errorf("key normalization rule %s has invalid arg", raw)
+			return
+		}
+
+		switch action {
+		case KRDrop:
+			kn.drop[arg] = true
+		case KRDefault:
+			kn.defaults[arg] = value
+		case KRLower:
+			kn.lower[arg] = true
+		case KRTrim:
+			kn.trim[arg] = true
+		}
+	}
+
+	return kn, e
+}
+
+func (m *KeyNormalization) IsEmpty() bool {
+	return m == nil || len(m.drop)+len(m.defaults)+len(m.lower)+len(m.trim) == 0
+}
+
+// normalize copies args for the cache key with applied rules; args order is kept,
+// value transformations are applied before comparing with the default one
+func (m *KeyNormalization) normalize(src, dst *fasthttp.Args) {
+	src.VisitAll(func(key, value []byte) {
+		arg := futils.UnsafeString(key)
+
+		if m.drop[arg] {
+			return
+		}
+
+		if m.trim[arg] {
+			value = bytes.TrimSpace(value)
+		}
+
+		if m.lower[arg] {
+			value = bytes.ToLower(value)
+		}
+
+		if def, ok := m.defaults[arg]; ok && futils.UnsafeString(value) == def {
+			return
+		}
+
+		dst.AddBytesKV(key, value)
+	})
+}
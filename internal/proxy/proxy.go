@@ -5,6 +5,8 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"sync"
+	"time"
 
 	"github.com/anilibria/alice/internal/anilibria"
 	"github.com/anilibria/alice/internal/cache"
@@ -19,20 +21,37 @@ import (
 )
 
 type Proxy struct {
-	client *ProxyClient
+	upstreams []*Upstream
+	balancer  balancer
+
 	config *ProxyConfig
 
 	cache      *cache.Cache
 	geoip      geoip.GeoIPClient
 	randomizer *anilibria.Randomizer
+
+	revalidating sync.Map
+	flights      *flightGroup
+	retries      *RetryPolicy
+	cookies      *CookiePolicy
+	tls          *tlsReloader
+
+	log  *zerolog.Logger
+	done func() <-chan struct{}
 }
 
 type ProxyConfig struct {
-	dstServer, dstHost string
-	apiSecret          []byte
+	dstHost   string
+	apiSecret []byte
+
+	healthcheck *HealthCheckConfig
+	validator   *ValidatorConfig
+
+	staleIfError         bool
+	staleWhileRevalidate bool
 }
 
-func NewProxy(c context.Context) *Proxy {
+func NewProxy(c context.Context) (_ *Proxy, e error) {
 	cli := c.Value(utils.CKCliCtx).(*cli.Context)
 
 	var randomizer *anilibria.Randomizer
@@ -45,19 +64,153 @@ func NewProxy(c context.Context) *Proxy {
 		gip = c.Value(utils.CKGeoIP).(geoip.GeoIPClient)
 	}
 
+	log := c.Value(utils.CKLogger).(*zerolog.Logger)
+
+	var tlsrl *tlsReloader
+	if tlsrl, e = newTLSReloader(cli, log); e != nil {
+		return
+	}
+
+	var upstreams []*Upstream
+	if upstreams, e = newUpstreams(cli, log, tlsrl); e != nil {
+		return
+	}
+
+	var blncr balancer
+	if blncr, e = newBalancer(cli.String("proxy-balancer"), upstreams); e != nil {
+		return
+	}
+
+	var retries *RetryPolicy
+	if retries, e = NewRetryPolicy(cli); e != nil {
+		return
+	}
+
+	var cookies *CookiePolicy
+	if cookies, e = NewCookiePolicy(cli); e != nil {
+		return
+	}
+
+	var whitelists *WhitelistStore
+	if whitelists, e = NewWhitelistStore(cli, log); e != nil {
+		return
+	}
+
+	var normalization *KeyNormalization
+	if normalization, e = NewKeyNormalization(cli.String("cache-key-normalize")); e != nil {
+		return
+	}
+
+	var vary *VaryPolicy
+	if vary, e = NewVaryPolicy(cli.String("cache-key-headers")); e != nil {
+		return
+	}
+
+	var sessions *SessionPolicy
+	if sessions, e = NewSessionPolicy(cli); e != nil {
+		return
+	}
+
+	var routes routeGroups
+	if routes, e = NewRouteGroups(cli.String("cache-route-groups")); e != nil {
+		return
+	}
+
+	if cli.Bool("cache-key-method") && cli.Bool("cache-get-share-post") {
+		e = errors.New("cache-get-share-post could not be used with cache-key-method")
+		return
+	}
+
+	var trust *CustomHeadersTrust
+	if trust, e = NewCustomHeadersTrust(cli); e != nil {
+		return
+	}
+
+	var flights *flightGroup
+	if cli.Bool("cache-coalescing-enable") {
+		flights = newFlightGroup(cli.Duration("cache-coalescing-timeout"))
+	}
+
 	return &Proxy{
-		client: NewClient(cli),
+		upstreams: upstreams,
+		balancer:  blncr,
+
 		config: &ProxyConfig{
-			dstServer: cli.String("proxy-dst-server"),
 			dstHost:   cli.String("proxy-dst-host"),
 			apiSecret: []byte(cli.String("cache-api-secret")),
+
+			healthcheck: &HealthCheckConfig{
+				enabled:  cli.Bool("proxy-healthcheck-enable"),
+				path:     []byte(cli.String("proxy-healthcheck-path")),
+				query:    []byte(cli.String("proxy-healthcheck-query")),
+				interval: cli.Duration("proxy-healthcheck-interval"),
+				timeout:  cli.Duration("proxy-healthcheck-timeout"),
+			},
+
+			validator: &ValidatorConfig{
+				whitelists: whitelists,
+
+				normalization: normalization,
+
+				vary:     vary,
+				sessions: sessions,
+
+				trust: trust,
+
+				routes: routes,
+
+				shareURLQuery: cli.Bool("cache-get-share-post"),
+				keyMethod:     cli.Bool("cache-key-method"),
+			},
+
+			staleIfError:         cli.Bool("cache-stale-if-error"),
+			staleWhileRevalidate: cli.Bool("cache-stale-while-revalidate"),
 		},
 
 		geoip:      gip,
 		randomizer: randomizer,
+		flights:    flights,
+		retries:    retries,
+		cookies:    cookies,
 
 		cache: c.Value(utils.CKCache).(*cache.Cache),
+
+		tls: tlsrl,
+
+		log:  log,
+		done: c.Done,
+	}, e
+}
+
+func (m *Proxy) Bootstrap() {
+	var wg sync.WaitGroup
+
+	if m.tls != nil {
+		wg.Add(1)
+
+		go func() {
+			m.tls.loop(m.done)
+			wg.Done()
+		}()
 	}
+
+	if m.config.healthcheck.enabled {
+		m.loop()
+	} else {
+		m.log.Debug().Msg("upstream health checks are disabled")
+		<-m.done()
+	}
+
+	wg.Wait()
+}
+
+// ReloadWhitelists replaces request validation lists with the ones from proxy-whitelists-file
+func (m *Proxy) ReloadWhitelists() (e error) {
+	if e = m.config.validator.whitelists.Reload(); e != nil {
+		m.log.Error().Msg("could not reload whitelists, keep the current ones - " + e.Error())
+	}
+
+	return
 }
 
 func (m *Proxy) ProxyFiberRequest(c *fiber.Ctx) (e error) {
@@ -83,6 +236,12 @@ func (m *Proxy) ProxyCachedRequest(c *fiber.Ctx) (e error) {
 	return m.respondFromCache(c)
 }
 
+// requestArgs returns sorted request args parsed by Validator
+func requestArgs(c *fiber.Ctx) *fasthttp.Args {
+	args, _ := c.Context().UserValue(utils.UVRequestArgs).(*fasthttp.Args)
+	return args
+}
+
 func (*Proxy) IsCacheBypass(c *fiber.Ctx) bool {
 	key := c.Context().UserValue(utils.UVCacheKey).(*Key)
 	return key.Len() == 0
@@ -96,28 +255,136 @@ func (m *Proxy) acquireRewritedRequest(c *fiber.Ctx) *fasthttp.Request {
 	req := fasthttp.AcquireRequest()
 	c.Context().Request.CopyTo(req)
 
-	req.SetBodyRaw(c.BodyRaw())
+	req.SetBodyRaw(requestBody(c))
+
+	switch requestContentType(c) {
+	case utils.CTApplicationJSON:
+		// PHP backend understands urlencoded bodies only
+		req.Header.SetContentType("application/x-www-form-urlencoded")
+	case utils.CTURLQuery:
+		// upstream response body is required for caching, HEAD response is sent without it anyway
+		req.Header.SetMethod(fiber.MethodGet)
+	}
 
 	req.Header.SetHost(m.config.dstHost)
 	req.UseHostHeader = true
 
+	// control headers are meant for ALICE only
+	stripCustomHeaders(req)
+
+	// responses are encoded by ALICE itself, so upstream must respond with plain body
+	req.Header.Del(fiber.HeaderAcceptEncoding)
+
 	return req
 }
 
+// requestBody returns the body for the upstream; JSON body is sent as sorted urlencoded args
+func requestBody(c *fiber.Ctx) []byte {
+	if requestContentType(c) != utils.CTApplicationJSON {
+		return c.BodyRaw()
+	}
+
+	return requestArgs(c).QueryString()
+}
+
 func (m *Proxy) doRequest(c *fiber.Ctx, req *fasthttp.Request, rsp *fasthttp.Response) (e error) {
-	if e = m.client.Do(req, rsp); e != nil {
+	key := c.Context().UserValue(utils.UVCacheKey).(*Key)
+
+	retryable := m.retries.isRetryable(requestArgs(c))
+
+	e = m.sendRequest(rlog(c), key.Bytes(), retryable, req, rsp)
+
+	// mutating request could change the user data even if it has failed
+	if session := requestSession(c); session != nil && session.mutating {
+		m.config.validator.sessions.invalidate(session.id)
+	}
+
+	if e != nil {
 		return
 	}
 
+	var cacheable bool
+	if cacheable, e = m.inspectResponse(rlog(c), rsp); e != nil {
+		return
+	}
+
+	// cookies are left in the response only if it's not cacheable
+	if passCookies(c, rsp) {
+		c.Response().Header.Set("X-Alice-Cache", "BYPASS")
+	}
+
+	if !cacheable {
+		m.bypassCache(c)
+	}
+
+	return
+}
+
+func (m *Proxy) sendRequest(log *zerolog.Logger, key []byte, retryable bool,
+	req *fasthttp.Request, rsp *fasthttp.Response) (e error) {
+	m.retries.budget.request()
+
+	var upstream *Upstream
+	for attempt := 0; ; attempt++ {
+		if upstream = m.selectUpstream(key, upstream); upstream == nil {
+			return errors.New("there are no available upstreams, all circuits are open")
+		}
+
+		if zerolog.GlobalLevel() < zerolog.InfoLevel {
+			log.Trace().Msg("selected upstream " + upstream.Addr())
+		}
+
+		e = upstream.Do(req, rsp)
+
+		if !retryable || attempt >= m.retries.attempts || !isRetryableResponse(e, rsp) {
+			return
+		}
+
+		if !m.retries.budget.withdraw() {
+			log.Warn().Msg("retry budget is exhausted, upstream request will not be retried")
+			return
+		}
+
+		if e != nil {
+			log.Info().Msgf("retrying upstream request (%d/%d) - %s", attempt+1, m.retries.attempts, e.Error())
+		} else {
+			log.Info().Msgf("retrying upstream request (%d/%d) - status %d",
+				attempt+1, m.retries.attempts, rsp.StatusCode())
+		}
+
+		rsp.Reset()
+		time.Sleep(m.retries.delay(attempt))
+	}
+}
+
+// selectUpstream chooses upstream for the request or its retry (if last is not nil)
+func (m *Proxy) selectUpstream(key []byte, last *Upstream) (upstream *Upstream) {
+	if last != nil {
+		switch m.retries.upstream {
+		case RUSame:
+			if last.IsAvailable() {
+				return last
+			}
+		case RUDifferent:
+			if upstream = m.balancer.next(key, last); upstream != nil {
+				return
+			}
+		}
+	}
+
+	return m.balancer.next(key, nil)
+}
+
+// inspectResponse returns an error if upstream response could not be sent to the client;
+// otherwise it reports whether the response can be cached
+func (m *Proxy) inspectResponse(log *zerolog.Logger, rsp *fasthttp.Response) (_ bool, e error) {
 	status, body := rsp.StatusCode(), rsp.Body()
 
 	if status < fasthttp.StatusOK || status >= fasthttp.StatusInternalServerError {
 		e = fmt.Errorf("proxy server respond with status %d", status)
 		return
 	} else if status >= fiber.StatusBadRequest {
-		rlog(c).Info().Msgf("status %d detected for request, bypass cache", status)
-
-		m.bypassCache(c)
+		log.Info().Msgf("status %d detected for request, bypass cache", status)
 		return
 	}
 
@@ -126,29 +393,19 @@ func (m *Proxy) doRequest(c *fiber.Ctx, req *fasthttp.Request, rsp *fasthttp.Res
 		return
 	}
 
-	if cookie := rsp.Header.Peek("Set-Cookie"); len(cookie) != 0 {
-		key := c.Context().UserValue(utils.UVCacheKey).(*Key)
-		key.Reset()
-		c.Response().Header.Set("X-Alice-Cache", "BYPASS")
-		c.Response().Header.Set("Set-Cookie", string(cookie))
-		// TODO: refactor
-	}
-
 	var ok bool
-	if ok, e = m.unmarshalApiResponse(c, rsp); e != nil {
-		rlog(c).Warn().Msg(e.Error())
-		m.bypassCache(c)
-	} else if !ok {
-		m.bypassCache(c)
+	if ok, e = m.unmarshalApiResponse(log, rsp); e != nil {
+		log.Warn().Msg(e.Error())
+		return false, nil
 	}
 
-	return
+	return ok && m.cookies.isCacheable(log, rsp), nil
 }
 
-func (*Proxy) unmarshalApiResponse(c *fiber.Ctx, rsp *fasthttp.Response) (ok bool, e error) {
+func (*Proxy) unmarshalApiResponse(log *zerolog.Logger, rsp *fasthttp.Response) (ok bool, e error) {
 	var apirsp *utils.ApiResponseWOData
 	if apirsp, e = utils.UnmarshalApiResponse(rsp.Body()); e != nil || apirsp == nil {
-		rlog(c).Warn().Msg("could not parse legacy api response - " + futils.UnsafeString(rsp.Body()))
+		log.Warn().Msg("could not parse legacy api response - " + futils.UnsafeString(rsp.Body()))
 		return
 	}
 	defer utils.ReleaseApiResponseWOData(apirsp)
@@ -160,21 +417,21 @@ func (*Proxy) unmarshalApiResponse(c *fiber.Ctx, rsp *fasthttp.Response) (ok boo
 
 	if apirsp.Error == nil {
 		if zerolog.GlobalLevel() <= zerolog.DebugLevel {
-			rlog(c).Trace().Msg(futils.UnsafeString(rsp.Body()))
-			rlog(c).Trace().Msgf("%+v", apirsp)
-			rlog(c).Trace().Msgf("%+v", apirsp.Error)
+			log.Trace().Msg(futils.UnsafeString(rsp.Body()))
+			log.Trace().Msgf("%+v", apirsp)
+			log.Trace().Msgf("%+v", apirsp.Error)
 		}
 
-		rlog(c).Error().Msg("smth is wrong in dst response - status false and err == nil")
+		log.Error().Msg("smth is wrong in dst response - status false and err == nil")
 		return
 	}
 
 	if zerolog.GlobalLevel() <= zerolog.DebugLevel {
-		rlog(c).Trace().Msgf("%+v", apirsp)
-		rlog(c).Trace().Msgf("%+v", apirsp.Error)
+		log.Trace().Msgf("%+v", apirsp)
+		log.Trace().Msgf("%+v", apirsp.Error)
 	}
 
-	rlog(c).Info().Msgf("api server respond with %d - %s", apirsp.Error.Code, apirsp.Error.Message)
+	log.Info().Msgf("api server respond with %d - %s", apirsp.Error.Code, apirsp.Error.Message)
 	return
 }
 
@@ -191,8 +448,15 @@ func (m *Proxy) cacheResponse(c *fiber.Ctx, rsp *fasthttp.Response) (e error) {
 		rlog(c).Trace().Msgf("Key: %s", key.UnsafeString())
 	}
 
+	return m.storeResponse(country, key, m.entryTTL(c), rsp, &c.Response().Header)
+}
+
+// storeResponse caches the response body and its headers;
+// headers already defined in skip (ALICE own headers) will not be cached
+func (m *Proxy) storeResponse(country string, key *Key, ttl time.Duration, rsp *fasthttp.Response,
+	skip *fasthttp.ResponseHeader) (e error) {
 	// cache response body
-	if e = m.cache.Cache(country, key.UnsafeString(), rsp.Body()); e != nil {
+	if e = m.cache.CacheWithVariants(country, key.UnsafeString(), rsp.Body(), ttl); e != nil {
 		return
 	}
 
@@ -201,7 +465,7 @@ func (m *Proxy) cacheResponse(c *fiber.Ctx, rsp *fasthttp.Response) (e error) {
 	defer utils.ReleaseHeaderCache(headers)
 
 	rsp.Header.VisitAll(func(k, v []byte) {
-		if len(c.Response().Header.PeekBytes(k)) != 0 {
+		if len(skip.PeekBytes(k)) != 0 {
 			return
 		}
 
@@ -220,7 +484,7 @@ func (m *Proxy) cacheResponse(c *fiber.Ctx, rsp *fasthttp.Response) (e error) {
 		return
 	}
 
-	if e = m.cache.Cache(country, key.UnsafeHeadersKey(), buf.Bytes()); e != nil {
+	if e = m.cache.Cache(country, key.UnsafeHeadersKey(), buf.Bytes(), ttl); e != nil {
 		return
 	}
 
@@ -240,18 +504,31 @@ func (m *Proxy) canRespondFromCache(c *fiber.Ctx) (_ bool, e error) {
 	key := c.Context().UserValue(utils.UVCacheKey).(*Key)
 	country := m.countryByRemoteIP(c)
 
-	var ok bool
-	if ok, e = m.cache.IsCached(country, key.UnsafeString()); e != nil {
+	var state cache.EntryState
+	if state, e = m.cache.IsCached(country, key.UnsafeString()); e != nil {
 		c.Response().Header.Set("X-Alice-Cache", "FAILED")
 		rlog(c).Warn().Msg("there is problems with cache driver")
 		return
-	} else if !ok {
+	}
+
+	switch state {
+	case cache.ESFresh:
+		c.Response().Header.Set("X-Alice-Cache", "HIT")
+		return true, e
+	case cache.ESStale:
+		// stale-if-error mode is handled by HandleProxyToDst
+		if !m.config.staleWhileRevalidate {
+			c.Response().Header.Set("X-Alice-Cache", "MISS")
+			return
+		}
+
+		c.Response().Header.Set("X-Alice-Cache", "STALE")
+		m.revalidateInBackground(c, country, key)
+		return true, e
+	default:
 		c.Response().Header.Set("X-Alice-Cache", "MISS")
 		return
 	}
-
-	c.Response().Header.Set("X-Alice-Cache", "HIT")
-	return true, e
 }
 
 func (m *Proxy) respondFromCache(c *fiber.Ctx) (e error) {
@@ -277,9 +554,29 @@ func (m *Proxy) respondFromCache(c *fiber.Ctx) (e error) {
 		c.Response().Header.SetBytesKV(futils.UnsafeBytes(name), value)
 	}
 
-	// get body from cache
-	if e = m.cache.Write(country, key.UnsafeString(), c); e != nil {
+	// conditional requests support
+	var info cache.EntryInfo
+	if info, e = m.cache.Info(country, key.UnsafeString()); e != nil {
 		return
+	} else if info.State == cache.ESMissing {
+		return errors.New("cache entry has been evicted before the response")
+	}
+
+	enc := m.negotiateEncoding(c)
+	setValidators(c, &info, enc)
+
+	if isNotModified(c, &info) {
+		c.Response().Header.SetContentType(fiber.MIMEApplicationJSONCharsetUTF8)
+		return c.SendStatus(fiber.StatusNotModified)
+	}
+
+	// get body (or its encoded variant) from cache
+	if e = m.cache.WriteEncoded(country, key.UnsafeString(), enc, c); e != nil {
+		return
+	}
+
+	if enc != cache.EncIdentity {
+		c.Response().Header.Set(fiber.HeaderContentEncoding, cache.Enctos[enc])
 	}
 
 	return m.respondWithStatus(c, nil, fiber.StatusOK)
@@ -0,0 +1,156 @@
+package proxy
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	futils "github.com/gofiber/fiber/v2/utils"
+	"github.com/urfave/cli/v2"
+	"github.com/valyala/fasthttp"
+)
+
+type RetryUpstream uint8
+
+const (
+	RUSame RetryUpstream = iota
+	RUDifferent
+)
+
+var Storu = map[string]RetryUpstream{
+	"same":      RUSame,
+	"different": RUDifferent,
+}
+
+type RetryPolicy struct {
+	attempts int
+	upstream RetryUpstream
+
+	backoff    time.Duration
+	backoffMax time.Duration
+
+	queries map[string]bool
+	budget  *retryBudget
+}
+
+func NewRetryPolicy(c *cli.Context) (_ *RetryPolicy, e error) {
+	rp := &RetryPolicy{
+		attempts:   c.Int("proxy-retry-attempts"),
+		backoff:    c.Duration("proxy-retry-backoff"),
+		backoffMax: c.Duration("proxy-retry-backoff-max"),
+		queries:    make(map[string]bool),
+		budget:     newRetryBudget(c.Int("proxy-retry-budget"), c.Duration("proxy-retry-budget-window")),
+	}
+
+	var ok bool
+	if rp.upstream, ok = Storu[c.String("proxy-retry-upstream")]; !ok {
+		e = fmt.Errorf("unknown retry upstream mode %s", c.String("proxy-retry-upstream"))
+		return
+	}
+
+	for _, query := range strings.Split(c.String("proxy-retry-queries"), ",") {
+		if query = strings.TrimSpace(query); query == "" {
+			continue
+		}
+
+		if isMutatingQuery(query) {
+			e = fmt.Errorf("query %s is mutating and could not be retried", query)
+			return
+		}
+
+		rp.queries[query] = true
+	}
+
+	return rp, e
+}
+
+// isRetryable reports if the request is read-only and can be safely repeated;
+// requests without query (func.php login flows, etc.) are never retried
+func (m *RetryPolicy) isRetryable(args *fasthttp.Args) bool {
+	if m.attempts <= 0 || args == nil {
+		return false
+	}
+
+	query := futils.UnsafeString(args.Peek("query"))
+	if query == "" || isMutatingQuery(query) {
+		return false
+	}
+
+	return m.queries[query]
+}
+
+func isMutatingQuery(query string) bool {
+	return strings.HasPrefix(query, "auth_") || query == "favorites" || query == "social_auth"
+}
+
+// delay returns exponential backoff with full jitter
+func (m *RetryPolicy) delay(attempt int) time.Duration {
+	if m.backoff <= 0 {
+		return 0
+	}
+
+	backoff := m.backoff << uint(attempt)
+	if backoff <= 0 || (m.backoffMax > 0 && backoff > m.backoffMax) {
+		backoff = m.backoffMax
+	}
+
+	return time.Duration(rand.Int63n(int64(backoff) + 1)) // skipcq: GSC-G404 math/rand is enoght here
+}
+
+func isRetryableResponse(e error, rsp *fasthttp.Response) bool {
+	return e != nil || rsp.StatusCode() >= fasthttp.StatusInternalServerError
+}
+
+// retryBudget limits retries by the percentage of requests in the rolling window,
+// so retries could not amplify an upstream outage
+type retryBudget struct {
+	mu sync.Mutex
+
+	ratio  int
+	window time.Duration
+
+	resetAt  time.Time
+	requests int
+	retries  int
+}
+
+// a few retries are allowed even for the low traffic
+const retryBudgetMinRetries = 10
+
+func newRetryBudget(ratio int, window time.Duration) *retryBudget {
+	return &retryBudget{
+		ratio:   ratio,
+		window:  window,
+		resetAt: time.Now().Add(window),
+	}
+}
+
+func (m *retryBudget) request() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.rotate()
+	m.requests++
+}
+
+func (m *retryBudget) withdraw() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.rotate()
+
+	if m.retries >= retryBudgetMinRetries && m.retries*100 >= m.requests*m.ratio {
+		return false
+	}
+
+	m.retries++
+	return true
+}
+
+func (m *retryBudget) rotate() {
+	if now := time.Now(); now.After(m.resetAt) {
+		m.requests, m.retries, m.resetAt = 0, 0, now.Add(m.window)
+	}
+}
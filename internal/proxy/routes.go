@@ -0,0 +1,93 @@
+package proxy
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// cache keys of the previous format had no version, so the first versioned one is v2;
+// the version must be changed on every incompatible change of the key format
+const keyFormatVersion = "v2"
+
+// RouteGroup is a cached path prefix with its own cache key namespace
+type RouteGroup struct {
+	Name   string
+	Prefix string
+}
+
+// routeGroups is sorted by prefix length, so the longest prefix is matched first
+type routeGroups []*RouteGroup
+
+// cache-route-groups format - apiv1=/public/api,apiv2=/api/v2
+func NewRouteGroups(policy string) (_ routeGroups, e error) {
+	var groups routeGroups
+	names, prefixes := make(map[string]bool), make(map[string]bool)
+
+	for _, raw := range strings.Split(policy, ",") {
+		if raw = strings.TrimSpace(raw); raw == "" {
+			continue
+		}
+
+		name, prefix, ok := strings.Cut(raw, "=")
+		if !ok || name == "" || strings.ContainsAny(name, "|=") {
+			e = fmt.Errorf("route group %s has invalid name", raw)
+			return
+		}
+
+		if prefix = strings.TrimRight(prefix, "/"); !strings.HasPrefix(prefix, "/") {
+			e = fmt.Errorf("route group %s has invalid prefix, it must be started with /", raw)
+			return
+		}
+
+		if names[name] || prefixes[prefix] {
+			e = fmt.Errorf("route group %s is duplicated", raw)
+			return
+		}
+		names[name], prefixes[prefix] = true, true
+
+		groups = append(groups, &RouteGroup{Name: name, Prefix: prefix})
+	}
+
+	if len(groups) == 0 {
+		e = errors.New("at least one route group must be defined")
+		return
+	}
+
+	sort.SliceStable(groups, func(i, j int) bool {
+		return len(groups[i].Prefix) > len(groups[j].Prefix)
+	})
+
+	return groups, e
+}
+
+// lookup returns the group of the normalized request path or nil
+func (m routeGroups) lookup(path []byte) *RouteGroup {
+	for _, group := range m {
+		if !bytes.HasPrefix(path, []byte(group.Prefix)) {
+			continue
+		}
+
+		if len(path) == len(group.Prefix) || path[len(group.Prefix)] == '/' {
+			return group
+		}
+	}
+
+	return nil
+}
+
+// normalizeRoutePath trims the trailing slash of the path which is already
+// unescaped and cleaned by fasthttp
+func normalizeRoutePath(path []byte) []byte {
+	if len(path) > 1 && path[len(path)-1] == '/' {
+		return path[:len(path)-1]
+	}
+
+	return path
+}
+
+func (m *Proxy) RouteGroups() []*RouteGroup {
+	return m.config.validator.routes
+}
@@ -0,0 +1,147 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	futils "github.com/gofiber/fiber/v2/utils"
+)
+
+type ArgType uint8
+
+const (
+	ATString ArgType = iota
+	ATInt
+	ATBool
+)
+
+var Stoat = map[string]ArgType{
+	"string": ATString,
+	"int":    ATInt,
+	"bool":   ATBool,
+}
+
+// argSchema is a schema of the one apiv1 argument in the whitelists file; Example:
+// {"type": "int", "min": 1, "max": 100, "required": true}
+// {"type": "string", "maxLength": 64, "enum": ["asc", "desc"]}
+type argSchema struct {
+	Type      string   `json:"type"`
+	Required  bool     `json:"required"`
+	Min       *int64   `json:"min"`
+	Max       *int64   `json:"max"`
+	MaxLength int      `json:"maxLength"`
+	Enum      []string `json:"enum"`
+}
+
+type argRule struct {
+	atype    ArgType
+	required bool
+
+	min, max       int64
+	hasMin, hasMax bool
+
+	maxLength int
+	enum      map[string]interface{}
+}
+
+// argsSchema is a set of argument rules by query; * rules are applied to all queries;
+// args with query rules are allowed for this query even if they are not whitelisted
+type argsSchema map[string]map[string]*argRule
+
+func newArgsSchema(raw map[string]map[string]*argSchema, args map[string]interface{}) (_ argsSchema, e error) {
+	schema := make(argsSchema, len(raw))
+
+	for query, rawArgs := range raw {
+		schema[query] = make(map[string]*argRule, len(rawArgs))
+
+		for name, as := range rawArgs {
+			// query specific args are whitelisted by the schema itself
+			if _, ok := args[name]; !ok && query == "*" {
+				return nil, fmt.Errorf("common schema contains not whitelisted arg %s", name)
+			}
+
+			if schema[query][name], e = as.rule(); e != nil {
+				return nil, fmt.Errorf("invalid schema of arg %s in query %s - %s", name, query, e.Error())
+			}
+		}
+	}
+
+	return schema, e
+}
+
+func (m *argSchema) rule() (_ *argRule, e error) {
+	if m == nil {
+		return nil, errors.New("schema is empty")
+	}
+
+	rule := &argRule{
+		required:  m.Required,
+		maxLength: m.MaxLength,
+	}
+
+	var ok bool
+	if rule.atype, ok = Stoat[m.Type]; !ok {
+		return nil, fmt.Errorf("unknown type %s", m.Type)
+	}
+
+	if m.Min != nil {
+		rule.min, rule.hasMin = *m.Min, true
+	}
+
+	if m.Max != nil {
+		rule.max, rule.hasMax = *m.Max, true
+	}
+
+	switch {
+	case (rule.hasMin || rule.hasMax) && rule.atype != ATInt:
+		return nil, errors.New("min and max could be defined for int type only")
+	case rule.hasMin && rule.hasMax && rule.min > rule.max:
+		return nil, errors.New("min is greater than max")
+	case len(m.Enum) != 0 && rule.atype != ATString:
+		return nil, errors.New("enum could be defined for string type only")
+	case rule.maxLength < 0:
+		return nil, errors.New("maxLength could not be negative")
+	}
+
+	if len(m.Enum) != 0 {
+		rule.enum = make(map[string]interface{}, len(m.Enum))
+		for _, value := range m.Enum {
+			rule.enum[value] = nil
+		}
+	}
+
+	return rule, e
+}
+
+func (m *argRule) validate(name, value []byte) (e error) {
+	if m.maxLength != 0 && len(value) > m.maxLength {
+		return fmt.Errorf("api argument %s is longer than %d", name, m.maxLength)
+	}
+
+	switch m.atype {
+	case ATInt:
+		var num int64
+		if num, e = strconv.ParseInt(futils.UnsafeString(value), 10, 64); e != nil {
+			return fmt.Errorf("api argument %s must be an integer", name)
+		}
+
+		if (m.hasMin && num < m.min) || (m.hasMax && num > m.max) {
+			return fmt.Errorf("api argument %s is out of allowed range", name)
+		}
+	case ATBool:
+		if _, e = strconv.ParseBool(futils.UnsafeString(value)); e != nil {
+			return fmt.Errorf("api argument %s must be a boolean", name)
+		}
+	default:
+		if m.enum == nil {
+			return
+		}
+
+		if _, ok := m.enum[futils.UnsafeString(value)]; !ok {
+			return fmt.Errorf("api argument %s has not allowed value", name)
+		}
+	}
+
+	return
+}
@@ -0,0 +1,170 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anilibria/alice/internal/utils"
+	"github.com/gofiber/fiber/v2"
+	futils "github.com/gofiber/fiber/v2/utils"
+	"github.com/urfave/cli/v2"
+	"github.com/valyala/fasthttp"
+)
+
+// sessionID is a truncated sha256 of the session token;
+// raw tokens are never stored in cache keys
+type sessionID [16]byte
+
+type sessionScope struct {
+	id         sessionID
+	generation int64
+
+	// mutating request invalidates all session entries
+	mutating bool
+}
+
+// SessionPolicy allows caching of per-user queries (favorites, etc.);
+// the session is hashed into the cache key with a short ttl, and entries of the session
+// become unreachable when its generation is changed by a mutating request
+type SessionPolicy struct {
+	cookie, arg string
+
+	queries map[string]bool
+	actions map[string]bool
+
+	ttl       time.Duration
+	retention time.Duration
+
+	mu          sync.Mutex
+	generations map[sessionID]int64
+	cleanedAt   time.Time
+}
+
+func NewSessionPolicy(c *cli.Context) (_ *SessionPolicy, e error) {
+	if !c.Bool("cache-session-enable") {
+		return
+	}
+
+	sp := &SessionPolicy{
+		cookie: c.String("cache-session-cookie"),
+		arg:    c.String("cache-session-arg"),
+
+		queries: make(map[string]bool),
+		actions: make(map[string]bool),
+
+		ttl: c.Duration("cache-session-ttl"),
+		// generation must outlive all entries of the session, including the stale ones
+		retention: c.Duration("cache-session-ttl") + c.Duration("cache-stale-window"),
+
+		generations: make(map[sessionID]int64),
+		cleanedAt:   time.Now(),
+	}
+
+	if sp.cookie == "" && sp.arg == "" {
+		e = errors.New("cache-session-cookie or cache-session-arg must be defined for session-aware caching")
+		return
+	} else if sp.ttl <= 0 {
+		e = errors.New("cache-session-ttl must be greater than zero")
+		return
+	}
+
+	for _, query := range strings.Split(c.String("cache-session-queries"), ",") {
+		if query = strings.TrimSpace(query); query != "" {
+			sp.queries[query] = true
+		}
+	}
+
+	for _, action := range strings.Split(c.String("cache-session-invalidate-actions"), ",") {
+		if action = strings.TrimSpace(action); action != "" {
+			sp.actions[action] = true
+		}
+	}
+
+	return sp, e
+}
+
+// lookup returns the session scope of the request or nil if the query is not session-aware
+// or there is no session in the request
+func (m *SessionPolicy) lookup(c *fiber.Ctx, args *fasthttp.Args) *sessionScope {
+	if m == nil || !m.queries[futils.UnsafeString(args.Peek("query"))] {
+		return nil
+	}
+
+	var token []byte
+	if m.arg != "" {
+		token = args.Peek(m.arg)
+	}
+
+	if len(token) == 0 && m.cookie != "" {
+		token = c.Request().Header.Cookie(m.cookie)
+	}
+
+	if len(token) == 0 {
+		return nil
+	}
+
+	sum := sha256.Sum256(token)
+
+	scope := &sessionScope{
+		mutating: m.actions[futils.UnsafeString(args.Peek("action"))],
+	}
+	copy(scope.id[:], sum[:])
+
+	m.mu.Lock()
+	scope.generation = m.generations[scope.id]
+	m.mu.Unlock()
+
+	return scope
+}
+
+// appendKey appends session id and its generation to the cache key
+func (m *sessionScope) appendKey(key []byte) []byte {
+	var id [len(sessionID{}) * 2]byte
+	hex.Encode(id[:], m.id[:])
+
+	key = append(key, "|session="...)
+	key = append(key, id[:]...)
+	key = append(key, '.')
+	return strconv.AppendInt(key, m.generation, 10)
+}
+
+// invalidate changes the session generation, so all cached entries of the session become unreachable
+func (m *SessionPolicy) invalidate(id sessionID) {
+	now := time.Now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.generations[id] = now.UnixNano()
+
+	if now.Sub(m.cleanedAt) < m.retention {
+		return
+	}
+
+	for sid, generation := range m.generations {
+		if now.Sub(time.Unix(0, generation)) > m.retention {
+			delete(m.generations, sid)
+		}
+	}
+
+	m.cleanedAt = now
+}
+
+func requestSession(c *fiber.Ctx) *sessionScope {
+	scope, _ := c.Context().UserValue(utils.UVSession).(*sessionScope)
+	return scope
+}
+
+// entryTTL returns ttl for the request cache entry; session entries use their own short ttl
+func (m *Proxy) entryTTL(c *fiber.Ctx) time.Duration {
+	if requestSession(c) != nil {
+		return m.config.validator.sessions.ttl
+	}
+
+	return m.cache.TTL(requestArgs(c))
+}
@@ -0,0 +1,85 @@
+package proxy
+
+import (
+	"github.com/anilibria/alice/internal/cache"
+	"github.com/anilibria/alice/internal/utils"
+	"github.com/gofiber/fiber/v2"
+	"github.com/valyala/fasthttp"
+)
+
+// respondStaleOnError serves an expired entry from the stale grace area
+// if the upstream has failed (stale-if-error mode)
+func (m *Proxy) respondStaleOnError(c *fiber.Ctx, err error) (_ bool) {
+	if !m.config.staleIfError || m.IsCacheBypass(c) {
+		return
+	}
+
+	key := c.Context().UserValue(utils.UVCacheKey).(*Key)
+	country := m.countryByRemoteIP(c)
+
+	if state, e := m.cache.IsCached(country, key.UnsafeString()); e != nil || state == cache.ESMissing {
+		return
+	}
+
+	rlog(c).Warn().Msg("upstream has failed, responding with stale entry - " + err.Error())
+	c.Response().Header.Set("X-Alice-Cache", "STALE")
+
+	if e := m.respondFromCache(c); e != nil {
+		rlog(c).Error().Msg("could not respond with stale entry - " + e.Error())
+		return
+	}
+
+	return true
+}
+
+// revalidateInBackground refreshes the stale entry with the only one upstream request
+// while clients are served from the stale grace area (stale-while-revalidate mode)
+func (m *Proxy) revalidateInBackground(c *fiber.Ctx, country string, key *Key) {
+	flight := m.cache.ZoneByISO(country) + ":" + key.UnsafeString()
+	if _, loaded := m.revalidating.LoadOrStore(flight, struct{}{}); loaded {
+		return
+	}
+
+	// fiber context will be released after the response, so copy all we need
+	req := m.acquireRewritedRequest(c)
+	req.ResetBody()
+	req.SetBody(requestBody(c))
+
+	skip := &fasthttp.ResponseHeader{}
+	c.Response().Header.CopyTo(skip)
+
+	rkey := AcquireKey()
+	rkey.Put(key.Bytes())
+
+	ttl := m.entryTTL(c)
+	retryable := m.retries.isRetryable(requestArgs(c))
+
+	go func() {
+		defer m.revalidating.Delete(flight)
+		defer ReleaseKey(rkey)
+		defer fasthttp.ReleaseRequest(req)
+
+		rsp := fasthttp.AcquireResponse()
+		defer fasthttp.ReleaseResponse(rsp)
+
+		if e := m.sendRequest(m.log, rkey.Bytes(), retryable, req, rsp); e != nil {
+			m.log.Warn().Msg("could not revalidate stale entry - " + e.Error())
+			return
+		}
+
+		if ok, e := m.inspectResponse(m.log, rsp); e != nil {
+			m.log.Warn().Msg("could not revalidate stale entry - " + e.Error())
+			return
+		} else if !ok {
+			m.log.Info().Msg("upstream respond with uncacheable response, stale entry will not be revalidated")
+			return
+		}
+
+		if e := m.storeResponse(country, rkey, ttl, rsp, skip); e != nil {
+			m.log.Warn().Msg("could not cache revalidated response - " + e.Error())
+			return
+		}
+
+		m.log.Debug().Msg("stale entry has been revalidated - " + rkey.UnsafeString())
+	}()
+}
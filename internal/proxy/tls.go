@@ -0,0 +1,232 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/urfave/cli/v2"
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// tlsReloader keeps upstream CA bundle and client certificate up to date;
+// files are re-read when their modification time is changed
+type tlsReloader struct {
+	mu   sync.RWMutex
+	pool *x509.CertPool
+	cert *tls.Certificate
+
+	caPath, certPath, keyPath string
+	modTimes                  map[string]time.Time
+
+	minVersion uint16
+	serverName string
+	skipVerify bool
+
+	frequency time.Duration
+	log       *zerolog.Logger
+}
+
+func newTLSReloader(c *cli.Context, log *zerolog.Logger) (_ *tlsReloader, e error) {
+	if !c.Bool("proxy-tls-enable") {
+		return
+	}
+
+	minVersion, ok := tlsVersions[c.String("proxy-tls-min-version")]
+	if !ok {
+		e = fmt.Errorf("unsupported tls version %s", c.String("proxy-tls-min-version"))
+		return
+	}
+
+	rl := &tlsReloader{
+		caPath:   c.String("proxy-tls-ca"),
+		certPath: c.String("proxy-tls-cert"),
+		keyPath:  c.String("proxy-tls-key"),
+		modTimes: make(map[string]time.Time),
+
+		minVersion: minVersion,
+		serverName: c.String("proxy-tls-server-name"),
+		skipVerify: c.Bool("proxy-tls-insecure-skip-verify"),
+
+		frequency: c.Duration("proxy-tls-reload-frequency"),
+		log:       log,
+	}
+
+	if (rl.certPath == "") != (rl.keyPath == "") {
+		e = errors.New("both proxy-tls-cert and proxy-tls-key must be defined for mTLS")
+		return
+	}
+
+	if _, e = rl.reload(); e != nil {
+		return
+	}
+
+	return rl, e
+}
+
+// clientConfig returns nil if upstream tls is disabled; upstream certificate is verified
+// against proxy-tls-server-name or the upstream host (IP SANs are used for IP addresses)
+func (m *tlsReloader) clientConfig(addr string) *tls.Config {
+	if m == nil {
+		return nil
+	}
+
+	name := m.serverName
+	if name == "" {
+		if host, _, e := net.SplitHostPort(addr); e == nil {
+			name = host
+		} else {
+			name = addr
+		}
+	}
+
+	return &tls.Config{
+		MinVersion: m.minVersion,
+		ServerName: m.serverName,
+
+		// certificates are verified by VerifyConnection with the actual CA pool
+		InsecureSkipVerify: true, // skipcq: GSC-G402 verification is implemented in VerifyConnection
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			return m.verifyConnection(cs, name)
+		},
+		GetClientCertificate: m.getClientCertificate,
+	}
+}
+
+func (m *tlsReloader) loop(done func() <-chan struct{}) {
+	if m.frequency <= 0 {
+		return
+	}
+
+	m.log.Debug().Msg("initiate upstream tls reload loop...")
+	defer m.log.Debug().Msg("upstream tls reload loop has been closed")
+
+	ticker := time.NewTicker(m.frequency)
+	defer ticker.Stop()
+
+LOOP:
+	for {
+		select {
+		case <-done():
+			break LOOP
+		case <-ticker.C:
+			if reloaded, e := m.reload(); e != nil {
+				m.log.Error().Msg("could not reload upstream tls files, keep the current ones - " + e.Error())
+			} else if reloaded {
+				m.log.Info().Msg("upstream tls files have been changed and reloaded")
+			}
+		}
+	}
+}
+
+func (m *tlsReloader) reload() (_ bool, e error) {
+	var changed bool
+	for _, path := range []string{m.caPath, m.certPath, m.keyPath} {
+		if path == "" {
+			continue
+		}
+
+		var fstat os.FileInfo
+		if fstat, e = os.Stat(path); e != nil {
+			return
+		}
+
+		if !fstat.ModTime().Equal(m.modTimes[path]) {
+			changed = true
+		}
+	}
+
+	if !changed && len(m.modTimes) != 0 {
+		return
+	}
+
+	var pool *x509.CertPool
+	if m.caPath != "" {
+		var pem []byte
+		if pem, e = os.ReadFile(m.caPath); e != nil {
+			return
+		}
+
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			e = errors.New("there are no valid certificates in " + m.caPath)
+			return
+		}
+	}
+
+	var cert *tls.Certificate
+	if m.certPath != "" {
+		var pair tls.Certificate
+		if pair, e = tls.LoadX509KeyPair(m.certPath, m.keyPath); e != nil {
+			return
+		}
+
+		cert = &pair
+	}
+
+	for _, path := range []string{m.caPath, m.certPath, m.keyPath} {
+		if fstat, err := os.Stat(path); err == nil {
+			m.modTimes[path] = fstat.ModTime()
+		}
+	}
+
+	m.mu.Lock()
+	m.pool, m.cert = pool, cert
+	m.mu.Unlock()
+
+	return true, e
+}
+
+func (m *tlsReloader) getClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.cert == nil {
+		// no client certificate will be sent
+		return &tls.Certificate{}, nil
+	}
+
+	return m.cert, nil
+}
+
+func (m *tlsReloader) verifyConnection(cs tls.ConnectionState, name string) (e error) {
+	if m.skipVerify {
+		return
+	}
+
+	if len(cs.PeerCertificates) == 0 {
+		return errors.New("upstream has not presented any certificate")
+	} else if name == "" {
+		return errors.New("upstream name is unknown, proxy-tls-server-name must be defined")
+	}
+
+	m.mu.RLock()
+	pool := m.pool
+	m.mu.RUnlock()
+
+	// nil pool means the system CA bundle
+	opts := x509.VerifyOptions{
+		Roots:         pool,
+		DNSName:       name,
+		Intermediates: x509.NewCertPool(),
+	}
+
+	for _, cert := range cs.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+
+	_, e = cs.PeerCertificates[0].Verify(opts)
+	return
+}
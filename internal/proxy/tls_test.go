@@ -0,0 +1,274 @@
+package proxy
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/valyala/fasthttp"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+
+	key, e := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	tpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: "alice test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+
+	der, e := x509.CreateCertificate(rand.Reader, tpl, tpl, &key.PublicKey, key)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	cert, e := x509.ParseCertificate(der)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	return &testCA{
+		cert: cert,
+		key:  key,
+		pem:  pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+	}
+}
+
+// issue returns the certificate and its PEM encoded certificate and key
+func (m *testCA) issue(t *testing.T, usage x509.ExtKeyUsage, dnsNames []string, ips ...net.IP) (
+	tls.Certificate, []byte, []byte) {
+	t.Helper()
+
+	key, e := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	tpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: "alice test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		DNSNames:     dnsNames,
+		IPAddresses:  ips,
+	}
+
+	der, e := x509.CreateCertificate(rand.Reader, tpl, m.cert, &key.PublicKey, m.key)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	kder, e := x509.MarshalECPrivateKey(key)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: kder})
+
+	pair, e := tls.X509KeyPair(certPEM, keyPEM)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	return pair, certPEM, keyPEM
+}
+
+func newTestUpstream(t *testing.T, cert tls.Certificate, clientCA *testCA) *httptest.Server {
+	t.Helper()
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`{"status":true,"data":null,"error":null}`))
+	}))
+
+	srv.Config.ErrorLog = log.New(io.Discard, "", 0)
+	srv.TLS = &tls.Config{Certificates: []tls.Certificate{cert}}
+	if clientCA != nil {
+		srv.TLS.ClientAuth = tls.RequireAndVerifyClientCert
+		srv.TLS.ClientCAs = x509.NewCertPool()
+		srv.TLS.ClientCAs.AddCert(clientCA.cert)
+	}
+
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+func writeTestFile(t *testing.T, dir, name string, data []byte) string {
+	t.Helper()
+
+	path := filepath.Join(dir, name)
+	if e := os.WriteFile(path, data, 0600); e != nil {
+		t.Fatal(e)
+	}
+
+	return path
+}
+
+func newTestReloader(t *testing.T, caPath, certPath, keyPath, serverName string) *tlsReloader {
+	t.Helper()
+
+	nop := zerolog.Nop()
+	rl := &tlsReloader{
+		caPath:   caPath,
+		certPath: certPath,
+		keyPath:  keyPath,
+		modTimes: make(map[string]time.Time),
+
+		minVersion: tls.VersionTLS12,
+		serverName: serverName,
+
+		log: &nop,
+	}
+
+	if _, e := rl.reload(); e != nil {
+		t.Fatal(e)
+	}
+
+	return rl
+}
+
+func doTestRequest(rl *tlsReloader, addr string) error {
+	client := &fasthttp.HostClient{
+		Addr:      addr,
+		IsTLS:     true,
+		TLSConfig: rl.clientConfig(addr),
+	}
+	defer client.CloseIdleConnections()
+
+	req, rsp := fasthttp.AcquireRequest(), fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseRequest(req)
+	defer fasthttp.ReleaseResponse(rsp)
+
+	req.SetRequestURI("https://" + addr + "/public/api/index.php")
+	return client.DoTimeout(req, rsp, 5*time.Second)
+}
+
+func TestUpstreamTLSCustomCA(t *testing.T) {
+	ca, dir := newTestCA(t), t.TempDir()
+	cert, _, _ := ca.issue(t, x509.ExtKeyUsageServerAuth, nil, net.ParseIP("127.0.0.1"))
+	srv := newTestUpstream(t, cert, nil)
+
+	rl := newTestReloader(t, writeTestFile(t, dir, "ca.pem", ca.pem), "", "", "")
+	if e := doTestRequest(rl, srv.Listener.Addr().String()); e != nil {
+		t.Fatalf("upstream certificate signed by the custom CA must be accepted - %v", e)
+	}
+
+	// system CA bundle knows nothing about the test CA
+	if e := doTestRequest(newTestReloader(t, "", "", "", ""), srv.Listener.Addr().String()); e == nil {
+		t.Fatal("upstream certificate signed by the unknown CA must be rejected")
+	}
+}
+
+func TestUpstreamTLSWrongName(t *testing.T) {
+	ca, dir := newTestCA(t), t.TempDir()
+	cert, _, _ := ca.issue(t, x509.ExtKeyUsageServerAuth, []string{"api.example.org"}, net.ParseIP("10.0.0.1"))
+	srv := newTestUpstream(t, cert, nil)
+
+	caPath := writeTestFile(t, dir, "ca.pem", ca.pem)
+
+	if e := doTestRequest(newTestReloader(t, caPath, "", "", ""), srv.Listener.Addr().String()); e == nil {
+		t.Fatal("upstream certificate without the upstream IP in SANs must be rejected")
+	}
+
+	if e := doTestRequest(newTestReloader(t, caPath, "", "", "wrong.example.org"), srv.Listener.Addr().String()); e == nil {
+		t.Fatal("upstream certificate without proxy-tls-server-name in SANs must be rejected")
+	}
+
+	if e := doTestRequest(newTestReloader(t, caPath, "", "", "api.example.org"), srv.Listener.Addr().String()); e != nil {
+		t.Fatalf("upstream certificate with proxy-tls-server-name in SANs must be accepted - %v", e)
+	}
+}
+
+func TestUpstreamTLSClientCertificate(t *testing.T) {
+	ca, dir := newTestCA(t), t.TempDir()
+	cert, _, _ := ca.issue(t, x509.ExtKeyUsageServerAuth, nil, net.ParseIP("127.0.0.1"))
+	srv := newTestUpstream(t, cert, ca)
+
+	caPath := writeTestFile(t, dir, "ca.pem", ca.pem)
+
+	if e := doTestRequest(newTestReloader(t, caPath, "", "", ""), srv.Listener.Addr().String()); e == nil {
+		t.Fatal("upstream must reject the request without client certificate")
+	}
+
+	_, certPEM, keyPEM := ca.issue(t, x509.ExtKeyUsageClientAuth, nil)
+	rl := newTestReloader(t, caPath,
+		writeTestFile(t, dir, "cert.pem", certPEM), writeTestFile(t, dir, "key.pem", keyPEM), "")
+
+	if e := doTestRequest(rl, srv.Listener.Addr().String()); e != nil {
+		t.Fatalf("upstream must accept the request with client certificate - %v", e)
+	}
+}
+
+func TestUpstreamTLSReload(t *testing.T) {
+	oldCA, newCA, dir := newTestCA(t), newTestCA(t), t.TempDir()
+	cert, _, _ := newCA.issue(t, x509.ExtKeyUsageServerAuth, nil, net.ParseIP("127.0.0.1"))
+	srv := newTestUpstream(t, cert, newCA)
+
+	_, oldCertPEM, oldKeyPEM := oldCA.issue(t, x509.ExtKeyUsageClientAuth, nil)
+	caPath := writeTestFile(t, dir, "ca.pem", oldCA.pem)
+	certPath := writeTestFile(t, dir, "cert.pem", oldCertPEM)
+	keyPath := writeTestFile(t, dir, "key.pem", oldKeyPEM)
+
+	rl := newTestReloader(t, caPath, certPath, keyPath, "")
+	if e := doTestRequest(rl, srv.Listener.Addr().String()); e == nil {
+		t.Fatal("upstream certificate signed by the old CA must be rejected")
+	}
+
+	if reloaded, e := rl.reload(); e != nil || reloaded {
+		t.Fatalf("unchanged files must not be reloaded - %t, %v", reloaded, e)
+	}
+
+	_, newCertPEM, newKeyPEM := newCA.issue(t, x509.ExtKeyUsageClientAuth, nil)
+	writeTestFile(t, dir, "ca.pem", newCA.pem)
+	writeTestFile(t, dir, "cert.pem", newCertPEM)
+	writeTestFile(t, dir, "key.pem", newKeyPEM)
+
+	mtime := time.Now().Add(time.Minute)
+	for _, path := range []string{caPath, certPath, keyPath} {
+		if e := os.Chtimes(path, mtime, mtime); e != nil {
+			t.Fatal(e)
+		}
+	}
+
+	if reloaded, e := rl.reload(); e != nil || !reloaded {
+		t.Fatalf("changed files must be reloaded - %t, %v", reloaded, e)
+	}
+
+	if e := doTestRequest(rl, srv.Listener.Addr().String()); e != nil {
+		t.Fatalf("reloaded CA and client certificate must be used for new connections - %v", e)
+	}
+}
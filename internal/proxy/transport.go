@@ -0,0 +1,52 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/urfave/cli/v2"
+	"github.com/valyala/fasthttp"
+)
+
+type UpstreamTransport uint8
+
+const (
+	UTHTTP UpstreamTransport = iota
+	UTFastCGI
+)
+
+var Stout = map[string]UpstreamTransport{
+	"http":    UTHTTP,
+	"fastcgi": UTFastCGI,
+}
+
+// Transport sends the rewrited apiv1 request to the upstream
+// and fills the response that doRequest could inspect
+type Transport interface {
+	Do(req *fasthttp.Request, rsp *fasthttp.Response) error
+	DoTimeout(req *fasthttp.Request, rsp *fasthttp.Response, timeout time.Duration) error
+
+	UserAgent() string
+}
+
+func newTransport(c *cli.Context, log *zerolog.Logger, addr string, tlsrl *tlsReloader) (_ Transport, e error) {
+	transport, ok := Stout[c.String("proxy-transport")]
+	if !ok {
+		e = fmt.Errorf("unknown upstream transport %s", c.String("proxy-transport"))
+		return
+	}
+
+	switch transport {
+	case UTFastCGI:
+		if tlsrl != nil {
+			e = errors.New("fastcgi upstream transport could not be used with proxy-tls-enable")
+			return
+		}
+
+		return NewFastCGIClient(c, log, addr), e
+	default:
+		return NewClient(c, addr, tlsrl.clientConfig(addr)), e
+	}
+}
@@ -0,0 +1,145 @@
+package proxy
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/urfave/cli/v2"
+	"github.com/valyala/fasthttp"
+)
+
+// X-Cache-Signature format - <unix timestamp>:<hex hmac-sha256>
+const CHSignatureHeader = "X-Cache-Signature"
+
+// CustomHeadersTrust decides if the custom cache headers of the request could be honored;
+// they are trusted from the defined networks or if they are signed with the shared secret
+type CustomHeadersTrust struct {
+	networks []*net.IPNet
+
+	// the client address is taken from http-realip-header if the peer is a trusted proxy
+	behindProxy bool
+
+	secret []byte
+	ttl    time.Duration
+}
+
+func NewCustomHeadersTrust(c *cli.Context) (_ *CustomHeadersTrust, e error) {
+	ht := &CustomHeadersTrust{
+		behindProxy: len(c.String("http-trusted-proxies")) > 0,
+
+		secret: []byte(c.String("cache-control-secret")),
+		ttl:    c.Duration("cache-control-signature-ttl"),
+	}
+
+	for _, cidr := range strings.Split(c.String("cache-control-trusted-cidrs"), ",") {
+		if cidr = strings.TrimSpace(cidr); cidr == "" {
+			continue
+		}
+
+		var network *net.IPNet
+		if _, network, e = net.ParseCIDR(cidr); e != nil {
+			e = fmt.Errorf("could not parse trusted network %s - %s", cidr, e.Error())
+			return
+		}
+
+		ht.networks = append(ht.networks, network)
+	}
+
+	return ht, e
+}
+
+// isTrusted checks the client address and then the request signature
+func (m *CustomHeadersTrust) isTrusted(c *fiber.Ctx, customs CustomHeaders, args *fasthttp.Args) bool {
+	if ip := m.clientIP(c); ip != nil {
+		for _, network := range m.networks {
+			if network.Contains(ip) {
+				return true
+			}
+		}
+	}
+
+	return m.isSigned(c, customs, args)
+}
+
+// clientIP returns the real ip header value only if the direct peer is one of http-trusted-proxies,
+// it could be forged by anyone else (and fiber trusts it if there are no trusted proxies)
+func (m *CustomHeadersTrust) clientIP(c *fiber.Ctx) net.IP {
+	if m.behindProxy && c.IsProxyTrusted() {
+		return net.ParseIP(c.IP())
+	}
+
+	return c.Context().RemoteIP()
+}
+
+// signature message - "<timestamp>\n" + "<header>:<value>\n" for every present custom header
+// (in order override, prefix, suffix, bypass) + sorted urlencoded request args
+func (m *CustomHeadersTrust) isSigned(c *fiber.Ctx, customs CustomHeaders, args *fasthttp.Args) bool {
+	if len(m.secret) == 0 {
+		return false
+	}
+
+	ts, sig, ok := strings.Cut(c.Get(CHSignatureHeader), ":")
+	if !ok {
+		return false
+	}
+
+	unix, e := strconv.ParseInt(ts, 10, 64)
+	if e != nil {
+		return false
+	}
+
+	if skew := time.Since(time.Unix(unix, 0)); skew > m.ttl || skew < -m.ttl {
+		return false
+	}
+
+	signature, e := hex.DecodeString(sig)
+	if e != nil {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, m.secret)
+	mac.Write([]byte(ts))
+	mac.Write([]byte{'\n'})
+
+	for ch := CHCacheKeyOverride; ch <= CHCacheBypass; ch <<= 1 {
+		if customs&ch == 0 {
+			continue
+		}
+
+		mac.Write([]byte(CHtos[ch]))
+		mac.Write([]byte{':'})
+		mac.Write(c.Request().Header.Peek(CHtos[ch]))
+		mac.Write([]byte{'\n'})
+	}
+
+	mac.Write(args.QueryString())
+
+	return hmac.Equal(mac.Sum(nil), signature)
+}
+
+// stripCustomHeaders removes ALICE control headers from the upstream request
+func stripCustomHeaders(req *fasthttp.Request) {
+	for header := range Stoch {
+		req.Header.Del(header)
+	}
+
+	req.Header.Del(CHSignatureHeader)
+}
+
+func customHeadersNames(customs CustomHeaders) string {
+	names := make([]string, 0, len(CHtos))
+	for ch := CHCacheKeyOverride; ch <= CHCacheBypass; ch <<= 1 {
+		if customs&ch != 0 {
+			names = append(names, CHtos[ch])
+		}
+	}
+
+	return strings.Join(names, ",")
+}
@@ -0,0 +1,141 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/rs/zerolog"
+	"github.com/urfave/cli/v2"
+	"github.com/valyala/fasthttp"
+)
+
+var errCircuitOpen = errors.New("upstream circuit is open, request has been rejected")
+
+type Upstream struct {
+	client  Transport
+	circuit *circuitBreaker
+
+	addr   string
+	weight int
+
+	// last active health check result
+	checkedAt atomic.Int64
+	checkErr  atomic.Value
+
+	// counters
+	active   atomic.Int64
+	requests atomic.Uint64
+	failures atomic.Uint64
+}
+
+// proxy-dst-server format - 10.0.0.1:36080/2,10.0.0.2:36080,unix:/run/php/php-fpm.sock/2
+// weight is optional and equals 1 by default
+func newUpstreams(c *cli.Context, log *zerolog.Logger, tlsrl *tlsReloader) (upstreams []*Upstream, e error) {
+	for _, server := range strings.Split(c.String("proxy-dst-server"), ",") {
+		if server = strings.TrimSpace(server); server == "" {
+			continue
+		}
+
+		addr, weight := server, 1
+		if idx := upstreamWeightIndex(server); idx != -1 {
+			addr = server[:idx]
+
+			if weight, e = strconv.Atoi(server[idx+1:]); e != nil {
+				e = fmt.Errorf("could not parse weight of upstream %s - %s", server, e.Error())
+				return
+			} else if weight <= 0 {
+				e = fmt.Errorf("weight of upstream %s must be greater than zero", server)
+				return
+			}
+		}
+
+		if addr == "" {
+			e = fmt.Errorf("upstream %s has an empty address", server)
+			return
+		}
+
+		var client Transport
+		if client, e = newTransport(c, log, addr, tlsrl); e != nil {
+			return
+		}
+
+		upstreams = append(upstreams, &Upstream{
+			client: client,
+			circuit: newCircuitBreaker(
+				c.Int("proxy-circuit-failures"),
+				c.Int("proxy-circuit-halfopen-probes"),
+				c.Duration("proxy-circuit-open-timeout"),
+			),
+
+			addr:   addr,
+			weight: weight,
+		})
+	}
+
+	if len(upstreams) == 0 {
+		e = errors.New("there are no upstreams in proxy-dst-server")
+	}
+
+	return
+}
+
+// unix socket path contains slashes, so its last element is the weight only if it's a number
+func upstreamWeightIndex(server string) int {
+	idx := strings.LastIndexByte(server, '/')
+	if idx == -1 || !strings.HasPrefix(server, "unix:") {
+		return idx
+	}
+
+	if _, e := strconv.Atoi(server[idx+1:]); e != nil {
+		return -1
+	}
+
+	return idx
+}
+
+func (m *Upstream) Do(req *fasthttp.Request, rsp *fasthttp.Response) (e error) {
+	if !m.circuit.allow() {
+		return errCircuitOpen
+	}
+
+	m.active.Add(1)
+	defer m.active.Add(-1)
+
+	m.requests.Add(1)
+
+	if e = m.client.Do(req, rsp); e != nil || rsp.StatusCode() >= fasthttp.StatusInternalServerError {
+		m.failures.Add(1)
+		m.circuit.failure()
+		return
+	}
+
+	m.circuit.success()
+	return
+}
+
+func (m *Upstream) IsAvailable() bool {
+	return m.circuit.available()
+}
+
+func (m *Upstream) State() CircuitState {
+	return m.circuit.State()
+}
+
+func (m *Upstream) Addr() string {
+	return m.addr
+}
+
+func (m *Upstream) Active() int64 {
+	return m.active.Load()
+}
+
+func (m *Upstream) Requests() uint64 {
+	return m.requests.Load()
+}
+
+func (m *Upstream) Failures() uint64 {
+	return m.failures.Load()
+}
@@ -2,8 +2,10 @@ package proxy
 
 import (
 	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"mime/multipart"
 	"sync"
 
@@ -22,22 +24,48 @@ type Validator struct {
 	contentTypeRaw []byte
 
 	requestArgs *fasthttp.Args
+	keyArgs     *fasthttp.Args
 
 	cacheKey *Key
+	session  *sessionScope
+	route    *RouteGroup
 
+	config  *ValidatorConfig
+	lists   *Whitelists
 	customs CustomHeaders
 }
 
+// ValidatorConfig contains request validation lists and policies of the cache key building
+type ValidatorConfig struct {
+	whitelists *WhitelistStore
+
+	normalization *KeyNormalization
+
+	vary     *VaryPolicy
+	sessions *SessionPolicy
+
+	// trusted sources of custom headers
+	trust *CustomHeadersTrust
+
+	// cached route groups with their own key namespaces
+	routes routeGroups
+
+	// GET requests share cache entries with POST ones
+	shareURLQuery bool
+	// request method is a part of the cache key
+	keyMethod bool
+}
+
 var validatorPool = sync.Pool{
 	New: func() interface{} {
 		return new(Validator)
 	},
 }
 
-func AcquireValidator(c *fiber.Ctx, ctr []byte) (v *Validator) {
+func AcquireValidator(c *fiber.Ctx, ctr []byte, config *ValidatorConfig) (v *Validator) {
 	v = validatorPool.Get().(*Validator)
 
-	v.Ctx, v.contentTypeRaw = c, ctr
+	v.Ctx, v.contentTypeRaw, v.config = c, ctr, config
 	v.cacheKey = AcquireKey()
 	return
 }
@@ -47,6 +75,10 @@ func ReleaseValidator(v *Validator) {
 		fasthttp.ReleaseArgs(v.requestArgs)
 	}
 
+	if v.keyArgs != nil {
+		fasthttp.ReleaseArgs(v.keyArgs)
+	}
+
 	v.Reset()
 	validatorPool.Put(v)
 }
@@ -56,19 +88,27 @@ func ReleaseValidator(v *Validator) {
 //
 
 func (m *Validator) ValidateRequest() (e error) {
+	// the same lists snapshot is used for the whole validation even if they are reloaded
+	m.lists = m.config.whitelists.Load()
+
+	if m.route = m.config.routes.lookup(normalizeRoutePath(m.Request().URI().Path())); m.route == nil {
+		return errors.New("request path is not in any cached route group")
+	}
+
 	if m.contentType = m.validateContentType(); m.contentType == utils.CTInvalid {
 		return fmt.Errorf("invalid request content-type - %s",
 			futils.UnsafeString(m.contentTypeRaw))
 	}
 
-	m.validateCustomHeaders()
-
 	m.requestArgs = fasthttp.AcquireArgs()
 
 	if e = m.extractRequestKey(); e != nil {
 		return
 	}
 
+	// signature of custom headers covers request args, so they must be extracted before
+	m.validateCustomHeaders()
+
 	if !m.isArgsWhitelisted() {
 		return errors.New("invalid api arguments detected")
 	}
@@ -77,15 +117,36 @@ func (m *Validator) ValidateRequest() (e error) {
 		return errors.New("invalid query detected")
 	}
 
-	if m.isQueryBypassListed() {
+	if e = m.validateArgsSchema(); e != nil {
+		return
+	}
+
+	// bypass-listed per-user queries could be cached in session-aware mode
+	if m.session = m.config.sessions.lookup(m.Ctx, m.requestArgs); m.session != nil {
+		if m.session.mutating {
+			m.customs = m.customs | CHCacheBypass
+		}
+
+		m.Context().SetUserValue(utils.UVSession, m.session)
+	} else if m.isQueryBypassListed() {
 		m.customs = m.customs | CHCacheBypass
 	}
 
+	// drop volatile and default args from the cache key, upstream receives all of them
+	cachekey := m.requestArgs.QueryString()
+	if !m.config.normalization.IsEmpty() {
+		m.keyArgs = fasthttp.AcquireArgs()
+		m.config.normalization.normalize(m.requestArgs, m.keyArgs)
+
+		cachekey = m.keyArgs.QueryString()
+	}
+
 	// delete or update cache key for further request processing
 	// controlled by CustomHeaders
-	m.postValidationMutate(m.requestArgs.QueryString())
+	m.postValidationMutate(cachekey)
 
 	m.Context().SetUserValue(utils.UVCacheKey, m.cacheKey)
+	m.Context().SetUserValue(utils.UVRequestArgs, m.requestArgs)
 	return
 }
 
@@ -95,13 +156,16 @@ func (m *Validator) IsQueryEqual(equal []byte) bool {
 
 func (m *Validator) Reset() {
 	m.Context().RemoveUserValue(utils.UVCacheKey)
+	m.Context().RemoveUserValue(utils.UVRequestArgs)
+	m.Context().RemoveUserValue(utils.UVSession)
 	ReleaseKey(m.cacheKey)
 
 	m.contentType = 0
 	m.contentTypeRaw = m.contentTypeRaw[:0]
 
 	m.customs = 0
-	m.requestArgs, m.Ctx = nil, nil
+	m.requestArgs, m.keyArgs = nil, nil
+	m.session, m.route, m.config, m.lists, m.Ctx = nil, nil, nil, nil, nil
 }
 
 //
@@ -109,10 +173,32 @@ func (m *Validator) Reset() {
 //
 
 func (m *Validator) validateContentType() utils.RequestContentType {
-	ctype := futils.UnsafeString(m.contentTypeRaw)
+	if isURLQueryRequest(m.Ctx) {
+		return utils.CTURLQuery
+	}
+
+	return parseContentType(m.contentTypeRaw)
+}
+
+// requestContentType returns the source of the request args
+func requestContentType(c *fiber.Ctx) utils.RequestContentType {
+	if isURLQueryRequest(c) {
+		return utils.CTURLQuery
+	}
 
-	if idx := bytes.IndexByte(m.contentTypeRaw, byte(';')); idx > 0 {
-		ctype = futils.UnsafeString(m.contentTypeRaw[:idx])
+	return parseContentType(c.Request().Header.ContentType())
+}
+
+// GET and HEAD requests carry args in the URL query string, their content-type is ignored
+func isURLQueryRequest(c *fiber.Ctx) bool {
+	return c.Request().Header.IsGet() || c.Request().Header.IsHead()
+}
+
+func parseContentType(raw []byte) utils.RequestContentType {
+	ctype := futils.UnsafeString(raw)
+
+	if idx := bytes.IndexByte(raw, byte(';')); idx > 0 {
+		ctype = futils.UnsafeString(raw[:idx])
 	}
 
 	switch ctype {
@@ -120,6 +206,8 @@ func (m *Validator) validateContentType() utils.RequestContentType {
 		return utils.CTApplicationUrlencoded
 	case "multipart/form-data":
 		return utils.CTMultipartFormData
+	case "application/json":
+		return utils.CTApplicationJSON
 	default:
 		return utils.CTInvalid
 	}
@@ -127,10 +215,12 @@ func (m *Validator) validateContentType() utils.RequestContentType {
 }
 
 func (m *Validator) validateCustomHeaders() {
+	var customs CustomHeaders
+
 	for header, ch := range Stoch {
 		val := m.Request().Header.PeekBytes(futils.UnsafeBytes(header))
 		if len(val) != 0 {
-			m.customs = m.customs | ch
+			customs = customs | ch
 
 			if zerolog.GlobalLevel() <= zerolog.DebugLevel {
 				rlog(m.Ctx).Trace().Msg("found custom header " + header)
@@ -138,7 +228,19 @@ func (m *Validator) validateCustomHeaders() {
 		}
 	}
 
-	// some another header validation...
+	if customs == 0 {
+		return
+	}
+
+	// custom headers from untrusted clients are ignored, the request is processed as usual
+	if !m.config.trust.isTrusted(m.Ctx, customs, m.requestArgs) {
+		rlog(m.Ctx).Warn().Str("remote_addr", m.Context().RemoteIP().String()).
+			Str("headers", customHeadersNames(customs)).
+			Msg("custom headers from untrusted client have been ignored")
+		return
+	}
+
+	m.customs = m.customs | customs
 }
 
 func (m *Validator) postValidationMutate(cachekey []byte) {
@@ -158,6 +260,34 @@ func (m *Validator) postValidationMutate(cachekey []byte) {
 		return
 	}
 
+	vb := bytebufferpool.Get()
+	defer bytebufferpool.Put(vb)
+
+	// key format - v2|<route group>|<normalized path>|<method or *>|<args>...
+	vb.B = append(vb.B, keyFormatVersion...)
+	vb.B = append(vb.B, '|')
+	vb.B = append(vb.B, m.route.Name...)
+	vb.B = append(vb.B, '|')
+	vb.B = append(vb.B, normalizeRoutePath(m.Request().URI().Path())...)
+	vb.B = append(vb.B, '|')
+	vb.B = m.appendKeyMethod(vb.B)
+	vb.B = append(vb.B, '|')
+	vb.B = append(vb.B, cachekey...)
+
+	// add normalized values of the request headers defined in cache-key-headers
+	if !m.config.vary.IsEmpty() {
+		vb.B = m.config.vary.appendKey(vb.B, m.requestArgs.Peek("query"), &m.Request().Header, func(name string) {
+			m.Response().Header.Add(fiber.HeaderVary, name)
+		})
+	}
+
+	// add the hashed session for session-aware queries
+	if m.session != nil {
+		vb.B = m.session.appendKey(vb.B)
+	}
+
+	cachekey = vb.B
+
 	// mutate request cache-key
 	if has(CHCacheKeyPrefix) || has(CHCacheKeySuffix) {
 		bb := bytebufferpool.Get()
@@ -175,6 +305,20 @@ func (m *Validator) postValidationMutate(cachekey []byte) {
 	m.cacheKey.Put(cachekey)
 }
 
+// appendKeyMethod appends the request method or * if the method is not a part of the key;
+// HEAD requests are answered with GET responses, so they share the method
+func (m *Validator) appendKeyMethod(key []byte) []byte {
+	switch {
+	case m.contentType == utils.CTURLQuery && (m.config.keyMethod || !m.config.shareURLQuery):
+		// GET and POST requests with the same args are separated if they are not shared
+		return append(key, fiber.MethodGet...)
+	case m.config.keyMethod:
+		return append(key, m.Request().Header.Method()...)
+	default:
+		return append(key, '*')
+	}
+}
+
 func (m *Validator) extractRequestKey() (e error) {
 	// get requests content-type
 	switch m.contentType {
@@ -182,6 +326,10 @@ func (m *Validator) extractRequestKey() (e error) {
 		e = m.encodeQueryArgs()
 	case utils.CTMultipartFormData:
 		e = m.encodeFormData()
+	case utils.CTApplicationJSON:
+		e = m.encodeJSONBody()
+	case utils.CTURLQuery:
+		e = m.encodeURLQueryArgs()
 	}
 
 	return
@@ -197,11 +345,100 @@ func (m *Validator) encodeQueryArgs() (_ error) {
 		return errors.New("there is no args after query parsing")
 	}
 
+	return m.sortArgs()
+}
+
+func (m *Validator) encodeURLQueryArgs() (e error) {
+	if m.Context().QueryArgs().Len() == 0 {
+		return errors.New("there is no args in the request query string")
+	}
+	m.Context().QueryArgs().CopyTo(m.requestArgs)
+
+	return m.sortArgs()
+}
+
+// sortArgs sorts args for the cache key and rejects duplicated ones
+func (m *Validator) sortArgs() (e error) {
 	// ?
 	m.requestArgs.Sort(bytes.Compare)
+
+	// duplicates are next to each other after sorting
+	var prev []byte
+	m.requestArgs.VisitAll(func(key, _ []byte) {
+		if e == nil && prev != nil && bytes.Equal(prev, key) {
+			e = errors.New("duplicate api argument detected - " + futils.UnsafeString(key))
+		}
+
+		prev = key
+	})
+
 	return
 }
 
+// encodeJSONBody flattens JSON object into the same args as the equivalent urlencoded body;
+// nested objects and arrays are not supported, booleans are converted to 1 and 0
+func (m *Validator) encodeJSONBody() (e error) {
+	if len(m.Body()) == 0 {
+		return errors.New("empty body received")
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(m.Body()))
+	decoder.UseNumber()
+
+	var token json.Token
+	if token, e = decoder.Token(); e != nil {
+		return
+	} else if delim, ok := token.(json.Delim); !ok || delim != '{' {
+		return errors.New("json body must be an object")
+	}
+
+	for decoder.More() {
+		if token, e = decoder.Token(); e != nil {
+			return
+		}
+		key, _ := token.(string)
+
+		if token, e = decoder.Token(); e != nil {
+			return
+		}
+
+		var value string
+		switch v := token.(type) {
+		case string:
+			value = v
+		case json.Number:
+			value = v.String()
+		case bool:
+			if value = "0"; v {
+				value = "1"
+			}
+		case nil:
+		default:
+			return errors.New("nested json value detected - " + key)
+		}
+
+		if m.requestArgs.Has(key) {
+			return errors.New("duplicate api argument detected - " + key)
+		}
+
+		m.requestArgs.Add(key, value)
+	}
+
+	// closing brace and nothing after it
+	if _, e = decoder.Token(); e != nil {
+		return
+	} else if _, e = decoder.Token(); !errors.Is(e, io.EOF) {
+		return errors.New("unexpected data after json body")
+	}
+
+	if m.requestArgs.Len() == 0 {
+		return errors.New("there is no args after json parsing")
+	}
+
+	m.requestArgs.Sort(bytes.Compare)
+	return nil
+}
+
 func (m *Validator) encodeFormData() (e error) {
 	var form *multipart.Form
 	if form, e = m.MultipartForm(); errors.Is(e, fasthttp.ErrNoMultipartForm) {
@@ -216,6 +453,10 @@ func (m *Validator) encodeFormData() (e error) {
 	}
 
 	for k, v := range form.Value {
+		if len(v) != 1 {
+			return errors.New("multi-value api argument detected - " + k)
+		}
+
 		m.requestArgs.Add(k, v[0])
 
 		if zerolog.GlobalLevel() <= zerolog.DebugLevel {
@@ -223,16 +464,7 @@ func (m *Validator) encodeFormData() (e error) {
 		}
 	}
 
-	// TODO - with go1.21.0 we can use:
-	//
-	// m.requestArgs.Sort(func(x, y []byte) int {
-	// 	return cmp.Compare(strings.ToLower(a), strings.ToLower(b))
-	// })
-	//
-	// ? but in 1.19
 	m.requestArgs.Sort(bytes.Compare)
-
-	// more info - https://pkg.go.dev/slices#SortFunc
 	return
 }
 
@@ -249,10 +481,17 @@ func (m *Validator) isArgsWhitelisted() (_ bool) {
 	declinedKeysPtr := declinedKeysPool.Get().(*[]string)
 	declinedKeys := *declinedKeysPtr
 
+	// args of the query schema are allowed for this query only
+	specific := m.lists.schema[futils.UnsafeString(m.requestArgs.Peek("query"))]
+
 	m.requestArgs.VisitAll(func(key, _ []byte) {
-		if _, ok := postArgsWhitelist[futils.UnsafeString(key)]; !ok {
-			declinedKeys = append(declinedKeys, futils.UnsafeString(key))
+		if _, ok := m.lists.args[futils.UnsafeString(key)]; ok {
+			return
+		} else if _, ok = specific[futils.UnsafeString(key)]; ok {
+			return
 		}
+
+		declinedKeys = append(declinedKeys, futils.UnsafeString(key))
 	})
 
 	var ok bool = true
@@ -279,20 +518,60 @@ func (m *Validator) isQueryWhitelisted() (ok bool) {
 		return true
 	}
 
-	if _, ok = queryWhitelist[futils.UnsafeString(query)]; !ok {
+	if _, ok = m.lists.queries[futils.UnsafeString(query)]; !ok {
 		rlog(m.Ctx).Debug().Msg("Invalid query-key detected - " + futils.UnsafeString(query))
 	}
 
 	return
 }
 
+// validateArgsSchema checks arg values by the query schema and the common (*) one;
+// args without schema are not checked
+func (m *Validator) validateArgsSchema() (e error) {
+	if len(m.lists.schema) == 0 {
+		return
+	}
+
+	common := m.lists.schema["*"]
+	specific := m.lists.schema[futils.UnsafeString(m.requestArgs.Peek("query"))]
+
+	m.requestArgs.VisitAll(func(key, value []byte) {
+		if e != nil {
+			return
+		}
+
+		rule, ok := specific[futils.UnsafeString(key)]
+		if !ok {
+			rule = common[futils.UnsafeString(key)]
+		}
+
+		if rule != nil {
+			e = rule.validate(key, value)
+		}
+	})
+
+	if e != nil {
+		return
+	}
+
+	for _, rules := range [2]map[string]*argRule{common, specific} {
+		for name, rule := range rules {
+			if rule.required && !m.requestArgs.Has(name) {
+				return errors.New("required api argument is missing - " + name)
+			}
+		}
+	}
+
+	return
+}
+
 func (m *Validator) isQueryBypassListed() (ok bool) {
 	var query []byte
 	if query = m.requestArgs.PeekBytes([]byte("query")); len(query) == 0 {
 		return true
 	}
 
-	_, ok = queryBypasslist[futils.UnsafeString(query)]
+	_, ok = m.lists.bypass[futils.UnsafeString(query)]
 	return ok
 }
 
@@ -0,0 +1,192 @@
+package proxy
+
+import (
+	"bytes"
+	"fmt"
+	"net/textproto"
+	"strconv"
+	"strings"
+
+	"github.com/valyala/fasthttp"
+)
+
+type KeyNormalizer uint8
+
+const (
+	KNRaw KeyNormalizer = iota
+	KNLower
+	KNLang
+	KNVersion
+	KNUAClass
+)
+
+var Stokn = map[string]KeyNormalizer{
+	"raw":     KNRaw,
+	"lower":   KNLower,
+	"lang":    KNLang,
+	"version": KNVersion,
+	"uaclass": KNUAClass,
+}
+
+type varyHeader struct {
+	name       string
+	normalizer KeyNormalizer
+}
+
+// VaryPolicy is a list of request headers which normalized values are added to the cache key
+type VaryPolicy struct {
+	wildcard []*varyHeader
+	queries  map[string][]*varyHeader
+}
+
+// cache-key-headers format - *|X-App-Version|version,schedule|Accept-Language|lang
+// query * matches all queries; normalizer is optional and equals lower by default
+func NewVaryPolicy(policy string) (_ *VaryPolicy, e error) {
+	vp := &VaryPolicy{
+		queries: make(map[string][]*varyHeader),
+	}
+
+	for _, raw := range strings.Split(policy, ",") {
+		if raw = strings.TrimSpace(raw); raw == "" {
+			continue
+		}
+
+		parts := strings.Split(raw, "|")
+		if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+			e = fmt.Errorf("cache key header rule %s has invalid format", raw)
+			return
+		}
+
+		header := &varyHeader{name: textproto.CanonicalMIMEHeaderKey(parts[1]), normalizer: KNLower}
+		if len(parts) == 3 {
+			var ok bool
+			if header.normalizer, ok = Stokn[parts[2]]; !ok {
+				e = fmt.Errorf("cache key header rule %s has unknown normalizer %s", raw, parts[2])
+				return
+			}
+		}
+
+		if parts[0] == "*" {
+			vp.wildcard = append(vp.wildcard, header)
+		} else {
+			vp.queries[parts[0]] = append(vp.queries[parts[0]], header)
+		}
+	}
+
+	return vp, e
+}
+
+func (m *VaryPolicy) IsEmpty() bool {
+	return m == nil || (len(m.wildcard) == 0 && len(m.queries) == 0)
+}
+
+// appendKey appends normalized header values of the query to the cache key
+// and calls fn for every used header (for Vary response header)
+func (m *VaryPolicy) appendKey(key []byte, query []byte, header *fasthttp.RequestHeader, fn func(name string)) []byte {
+	for _, headers := range [2][]*varyHeader{m.wildcard, m.queries[string(query)]} {
+		for _, vh := range headers {
+			key = append(key, '|')
+			key = append(key, vh.name...)
+			key = append(key, '=')
+			key = vh.normalizer.appendNormalized(key, header.Peek(vh.name))
+
+			fn(vh.name)
+		}
+	}
+
+	return key
+}
+
+func (m KeyNormalizer) appendNormalized(dst, value []byte) []byte {
+	value = bytes.TrimSpace(value)
+
+	switch m {
+	case KNRaw:
+		return append(dst, value...)
+	case KNLang:
+		return appendLowerASCII(dst, preferredLanguage(value))
+	case KNVersion:
+		return append(dst, majorMinorVersion(value)...)
+	case KNUAClass:
+		return append(dst, userAgentClass(value)...)
+	default:
+		return appendLowerASCII(dst, value)
+	}
+}
+
+func appendLowerASCII(dst, value []byte) []byte {
+	for _, ch := range value {
+		if ch >= 'A' && ch <= 'Z' {
+			ch += 'a' - 'A'
+		}
+		dst = append(dst, ch)
+	}
+
+	return dst
+}
+
+// preferredLanguage returns the primary subtag of the most preferred language;
+// Accept-Language format - ru-RU,ru;q=0.9,en-US;q=0.8
+func preferredLanguage(accept []byte) (lang []byte) {
+	best := -1.0
+
+	for _, item := range bytes.Split(accept, []byte(",")) {
+		tag, params, _ := bytes.Cut(item, []byte(";"))
+		if tag = bytes.TrimSpace(tag); len(tag) == 0 || bytes.Equal(tag, []byte("*")) {
+			continue
+		}
+
+		q := 1.0
+		if params = bytes.TrimSpace(params); bytes.HasPrefix(params, []byte("q=")) {
+			var e error
+			if q, e = strconv.ParseFloat(string(params[2:]), 64); e != nil {
+				continue
+			}
+		}
+
+		if q > best {
+			lang, _, _ = bytes.Cut(tag, []byte("-"))
+			best = q
+		}
+	}
+
+	return
+}
+
+// majorMinorVersion returns the first two components of the version - 3.1.7 (build 55) -> 3.1
+func majorMinorVersion(value []byte) []byte {
+	var end, dots int
+	for ; end < len(value); end++ {
+		if value[end] == '.' {
+			if dots++; dots == 2 {
+				break
+			}
+		} else if value[end] < '0' || value[end] > '9' {
+			break
+		}
+	}
+
+	return bytes.TrimRight(value[:end], ".")
+}
+
+func userAgentClass(ua []byte) string {
+	if len(ua) == 0 {
+		return ""
+	}
+
+	lower := appendLowerASCII(make([]byte, 0, len(ua)), ua)
+
+	for _, marker := range []string{"bot", "crawler", "spider"} {
+		if bytes.Contains(lower, []byte(marker)) {
+			return "bot"
+		}
+	}
+
+	for _, marker := range []string{"mobile", "android", "iphone", "ipad"} {
+		if bytes.Contains(lower, []byte(marker)) {
+			return "mobile"
+		}
+	}
+
+	return "desktop"
+}
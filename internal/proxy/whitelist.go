@@ -1,5 +1,19 @@
 package proxy
 
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+
+	"github.com/rs/zerolog"
+	"github.com/urfave/cli/v2"
+)
+
+// built-in lists are used if proxy-whitelists-file is not defined
+
 // var getArgsWhitelist = map[string]interface{}{
 // 	"query": nil,
 // }
@@ -101,3 +115,165 @@ var queryBypasslist = map[string]interface{}{
 	"auth_login_otp":  nil,
 	"favorites":       nil,
 }
+
+// args of the query schema are whitelisted for this query only, so random_release
+// filters never reach the upstream and do not fragment cache keys of the other queries
+var queryArgsSchema = argsSchema{
+	"random_release": {
+		"genre":   {atype: ATString, maxLength: 64},
+		"ongoing": {atype: ATString, enum: map[string]interface{}{"0": nil, "1": nil}},
+		"season":  {atype: ATString, maxLength: 32},
+		"type":    {atype: ATString, maxLength: 64},
+		"year":    {atype: ATInt, min: 1, hasMin: true},
+	},
+}
+
+// Whitelists is an immutable snapshot of the request validation lists;
+// snapshots are replaced atomically, so in-flight requests always use the consistent one
+type Whitelists struct {
+	args    map[string]interface{}
+	queries map[string]interface{}
+	bypass  map[string]interface{}
+
+	schema argsSchema
+}
+
+// proxy-whitelists-file format -
+// {"args": ["query", "id"], "queries": ["release", "favorites"], "bypass": ["favorites"],
+// "schema": {"release": {"id": {"type": "int", "min": 1}}, "*": {"page": {"type": "int"}}}};
+// args of the query schema could be omitted in args, then they are allowed for the query only;
+// built-in schemas (random_release filters) are merged in unless the file defines the same query
+type whitelistsFile struct {
+	Args    []string `json:"args"`
+	Queries []string `json:"queries"`
+	Bypass  []string `json:"bypass"`
+
+	Schema map[string]map[string]*argSchema `json:"schema"`
+}
+
+type WhitelistStore struct {
+	path string
+
+	mu    sync.Mutex
+	lists atomic.Pointer[Whitelists]
+
+	log *zerolog.Logger
+}
+
+func NewWhitelistStore(c *cli.Context, log *zerolog.Logger) (_ *WhitelistStore, e error) {
+	ws := &WhitelistStore{
+		path: c.String("proxy-whitelists-file"),
+		log:  log,
+	}
+
+	ws.lists.Store(&Whitelists{
+		args:    postArgsWhitelist,
+		queries: queryWhitelist,
+		bypass:  queryBypasslist,
+
+		schema: queryArgsSchema,
+	})
+
+	if ws.path != "" {
+		e = ws.Reload()
+	}
+
+	return ws, e
+}
+
+func (m *WhitelistStore) Load() *Whitelists {
+	return m.lists.Load()
+}
+
+// Reload reads and validates the whitelists file; the current lists stay in use if it's invalid
+func (m *WhitelistStore) Reload() (e error) {
+	if m.path == "" {
+		return errors.New("proxy-whitelists-file is not defined, built-in whitelists could not be reloaded")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var payload []byte
+	if payload, e = os.ReadFile(m.path); e != nil {
+		return
+	}
+
+	var wf *whitelistsFile
+	if e = json.Unmarshal(payload, &wf); e != nil {
+		return fmt.Errorf("could not parse whitelists file - %s", e.Error())
+	} else if wf == nil {
+		return errors.New("whitelists file is empty")
+	}
+
+	var lists *Whitelists
+	if lists, e = wf.whitelists(); e != nil {
+		return
+	}
+
+	m.lists.Store(lists)
+
+	m.log.Info().Msgf("whitelists have been loaded from %s; args %d, queries %d, bypass %d, schemas %d",
+		m.path, len(lists.args), len(lists.queries), len(lists.bypass), len(lists.schema))
+	return
+}
+
+func (m *whitelistsFile) whitelists() (_ *Whitelists, e error) {
+	lists := &Whitelists{}
+
+	if lists.args, e = listToSet("args", m.Args); e != nil {
+		return
+	} else if lists.queries, e = listToSet("queries", m.Queries); e != nil {
+		return
+	}
+
+	if len(lists.args) == 0 || len(lists.queries) == 0 {
+		return nil, errors.New("args and queries whitelists could not be empty")
+	} else if _, ok := lists.args["query"]; !ok {
+		return nil, errors.New("query arg must be whitelisted")
+	}
+
+	if lists.bypass, e = listToSet("bypass", m.Bypass); e != nil {
+		return
+	}
+
+	for query := range lists.bypass {
+		if _, ok := lists.queries[query]; !ok {
+			return nil, fmt.Errorf("bypass-listed query %s is not whitelisted", query)
+		}
+	}
+
+	for query := range m.Schema {
+		if _, ok := lists.queries[query]; !ok && query != "*" {
+			return nil, fmt.Errorf("schema is defined for not whitelisted query %s", query)
+		}
+	}
+
+	if lists.schema, e = newArgsSchema(m.Schema, lists.args); e != nil {
+		return
+	}
+
+	// built-in query schemas are kept unless the file redefines them,
+	// so random_release filters are not rejected with custom whitelists
+	for query, args := range queryArgsSchema {
+		if _, ok := lists.schema[query]; !ok {
+			lists.schema[query] = args
+		}
+	}
+
+	return lists, e
+}
+
+func listToSet(name string, list []string) (_ map[string]interface{}, e error) {
+	set := make(map[string]interface{}, len(list))
+
+	for _, item := range list {
+		if item == "" {
+			return nil, fmt.Errorf("%s whitelist contains an empty item", name)
+		}
+
+		set[item] = nil
+	}
+
+	return set, e
+}
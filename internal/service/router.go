@@ -171,6 +171,8 @@ func (m *Service) fiberRouterInitialization() {
 	cacheapi.Get("/dumpkeys", m.proxy.HandleCacheDumpKeys)
 	cacheapi.Post("/purge", m.proxy.HandleCachePurge)
 	cacheapi.Post("/purgeall", m.proxy.HandleCachePurgeAll)
+	cacheapi.Get("/upstreams", m.proxy.HandleUpstreamStats)
+	cacheapi.Post("/whitelists/reload", m.proxy.HandleWhitelistsReload)
 
 	//
 	// ALICE randomizer method for legacy www
@@ -181,12 +183,15 @@ func (m *Service) fiberRouterInitialization() {
 	//
 	// ALICE apiv1 requests proxying lifecycle:
 
-	// step1 - validate request
-	apiv1 := m.fb.Group("/public/api", m.proxy.MiddlewareValidation)
+	// groups are sorted by prefix length, so nested groups are registered before their parents
+	for _, group := range m.proxy.RouteGroups() {
+		// step1 - validate request
+		cached := m.fb.Group(group.Prefix, m.proxy.MiddlewareValidation)
 
-	// step2 - check cache availability and try to respond with it
-	apiv1.Use(skip.New(m.proxy.HandleProxyToCache, m.proxy.IsRequestCached))
+		// step2 - check cache availability and try to respond with it
+		cached.Use(skip.New(m.proxy.HandleProxyToCache, m.proxy.IsRequestCached))
 
-	// step3 - proxy request to upstream
-	apiv1.Use(m.proxy.HandleProxyToDst)
+		// step3 - proxy request to upstream
+		cached.Use(m.proxy.HandleProxyToDst)
+	}
 }
@@ -184,7 +184,9 @@ func (m *Service) Bootstrap() (e error) {
 
 	// randomizer module
 	if gCli.Bool("randomizer-enable") {
-		m.randomizer = anilibria.New(gCtx)
+		if m.randomizer, e = anilibria.New(gCtx); e != nil {
+			return
+		}
 		gCtx = context.WithValue(gCtx, utils.CKRandomizer, m.randomizer)
 
 		gofunc(&wg, m.randomizer.Bootstrap)
@@ -209,7 +211,10 @@ func (m *Service) Bootstrap() (e error) {
 	}
 
 	// proxy module
-	m.proxy = proxy.NewProxy(gCtx)
+	if m.proxy, e = proxy.NewProxy(gCtx); e != nil {
+		return
+	}
+	gofunc(&wg, m.proxy.Bootstrap)
 
 	// another subsystems
 	// ? write initialization block above the http
@@ -248,6 +253,9 @@ func (m *Service) loop(errs chan error, done func()) {
 	kernSignal := make(chan os.Signal, 1)
 	signal.Notify(kernSignal, syscall.SIGINT, syscall.SIGTERM, syscall.SIGTERM, syscall.SIGQUIT)
 
+	reloadSignal := make(chan os.Signal, 1)
+	signal.Notify(reloadSignal, syscall.SIGHUP)
+
 	gLog.Debug().Msg("initiate main event loop...")
 	defer gLog.Debug().Msg("main event loop has been closed")
 
@@ -258,6 +266,9 @@ LOOP:
 			gLog.Info().Msg("kernel signal has been caught; initiate application closing...")
 			gAbort()
 			break LOOP
+		case <-reloadSignal:
+			gLog.Info().Msg("SIGHUP has been caught; reloading whitelists...")
+			_ = m.proxy.ReloadWhitelists()
 		case err := <-errs:
 			gLog.Debug().Err(err).Msg("there are internal errors from one of application submodule")
 			m.loopError = err
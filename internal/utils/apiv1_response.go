@@ -21,6 +21,11 @@ type (
 	ApiResponseData struct {
 		Code string
 	}
+	ApiRawResponse struct {
+		Status bool
+		Data   easyjson.RawMessage
+		Error  *ApiError
+	}
 	ApiError struct {
 		Code        int
 		Message     string
@@ -84,11 +89,12 @@ func RespondWithApiError(status int, msg, desc string, w io.Writer) (e error) {
 	return
 }
 
-func RespondWithRandomRelease(code string, w io.Writer) (e error) {
-	apirsp := AcquireApiResponse()
-	defer ReleaseApiResponse(apirsp)
-
-	apirsp.Status, apirsp.Data.Code, apirsp.Error = true, code, nil
+// RespondWithRandomRelease responds with the release object of the backend (with selected fields)
+func RespondWithRandomRelease(release []byte, w io.Writer) (e error) {
+	apirsp := &ApiRawResponse{
+		Status: true,
+		Data:   release,
+	}
 
 	var buf []byte
 	if buf, e = easyjson.Marshal(apirsp); e != nil {
@@ -272,7 +272,99 @@ func (v *ApiResponse) UnmarshalJSON(data []byte) error {
 func (v *ApiResponse) UnmarshalEasyJSON(l *jlexer.Lexer) {
 	easyjson1e840bfDecodeGithubComAnilibriaAliceInternalUtils2(l, v)
 }
-func easyjson1e840bfDecodeGithubComAnilibriaAliceInternalUtils3(in *jlexer.Lexer, out *ApiError) {
+func easyjson1e840bfDecodeGithubComAnilibriaAliceInternalUtils3(in *jlexer.Lexer, out *ApiRawResponse) {
+	isTopLevel := in.IsStart()
+	if in.IsNull() {
+		if isTopLevel {
+			in.Consumed()
+		}
+		in.Skip()
+		return
+	}
+	in.Delim('{')
+	for !in.IsDelim('}') {
+		key := in.UnsafeFieldName(false)
+		in.WantColon()
+		if in.IsNull() {
+			in.Skip()
+			in.WantComma()
+			continue
+		}
+		switch key {
+		case "status":
+			out.Status = bool(in.Bool())
+		case "data":
+			(out.Data).UnmarshalEasyJSON(in)
+		case "error":
+			if in.IsNull() {
+				in.Skip()
+				out.Error = nil
+			} else {
+				if out.Error == nil {
+					out.Error = new(ApiError)
+				}
+				(*out.Error).UnmarshalEasyJSON(in)
+			}
+		default:
+			in.SkipRecursive()
+		}
+		in.WantComma()
+	}
+	in.Delim('}')
+	if isTopLevel {
+		in.Consumed()
+	}
+}
+func easyjson1e840bfEncodeGithubComAnilibriaAliceInternalUtils3(out *jwriter.Writer, in ApiRawResponse) {
+	out.RawByte('{')
+	first := true
+	_ = first
+	{
+		const prefix string = ",\"status\":"
+		out.RawString(prefix[1:])
+		out.Bool(bool(in.Status))
+	}
+	{
+		const prefix string = ",\"data\":"
+		out.RawString(prefix)
+		(in.Data).MarshalEasyJSON(out)
+	}
+	{
+		const prefix string = ",\"error\":"
+		out.RawString(prefix)
+		if in.Error == nil {
+			out.RawString("null")
+		} else {
+			(*in.Error).MarshalEasyJSON(out)
+		}
+	}
+	out.RawByte('}')
+}
+
+// MarshalJSON supports json.Marshaler interface
+func (v ApiRawResponse) MarshalJSON() ([]byte, error) {
+	w := jwriter.Writer{}
+	easyjson1e840bfEncodeGithubComAnilibriaAliceInternalUtils3(&w, v)
+	return w.Buffer.BuildBytes(), w.Error
+}
+
+// MarshalEasyJSON supports easyjson.Marshaler interface
+func (v ApiRawResponse) MarshalEasyJSON(w *jwriter.Writer) {
+	easyjson1e840bfEncodeGithubComAnilibriaAliceInternalUtils3(w, v)
+}
+
+// UnmarshalJSON supports json.Unmarshaler interface
+func (v *ApiRawResponse) UnmarshalJSON(data []byte) error {
+	r := jlexer.Lexer{Data: data}
+	easyjson1e840bfDecodeGithubComAnilibriaAliceInternalUtils3(&r, v)
+	return r.Error()
+}
+
+// UnmarshalEasyJSON supports easyjson.Unmarshaler interface
+func (v *ApiRawResponse) UnmarshalEasyJSON(l *jlexer.Lexer) {
+	easyjson1e840bfDecodeGithubComAnilibriaAliceInternalUtils3(l, v)
+}
+func easyjson1e840bfDecodeGithubComAnilibriaAliceInternalUtils4(in *jlexer.Lexer, out *ApiError) {
 	isTopLevel := in.IsStart()
 	if in.IsNull() {
 		if isTopLevel {
@@ -307,7 +399,7 @@ func easyjson1e840bfDecodeGithubComAnilibriaAliceInternalUtils3(in *jlexer.Lexer
 		in.Consumed()
 	}
 }
-func easyjson1e840bfEncodeGithubComAnilibriaAliceInternalUtils3(out *jwriter.Writer, in ApiError) {
+func easyjson1e840bfEncodeGithubComAnilibriaAliceInternalUtils4(out *jwriter.Writer, in ApiError) {
 	out.RawByte('{')
 	first := true
 	_ = first
@@ -332,23 +424,23 @@ func easyjson1e840bfEncodeGithubComAnilibriaAliceInternalUtils3(out *jwriter.Wri
 // MarshalJSON supports json.Marshaler interface
 func (v ApiError) MarshalJSON() ([]byte, error) {
 	w := jwriter.Writer{}
-	easyjson1e840bfEncodeGithubComAnilibriaAliceInternalUtils3(&w, v)
+	easyjson1e840bfEncodeGithubComAnilibriaAliceInternalUtils4(&w, v)
 	return w.Buffer.BuildBytes(), w.Error
 }
 
 // MarshalEasyJSON supports easyjson.Marshaler interface
 func (v ApiError) MarshalEasyJSON(w *jwriter.Writer) {
-	easyjson1e840bfEncodeGithubComAnilibriaAliceInternalUtils3(w, v)
+	easyjson1e840bfEncodeGithubComAnilibriaAliceInternalUtils4(w, v)
 }
 
 // UnmarshalJSON supports json.Unmarshaler interface
 func (v *ApiError) UnmarshalJSON(data []byte) error {
 	r := jlexer.Lexer{Data: data}
-	easyjson1e840bfDecodeGithubComAnilibriaAliceInternalUtils3(&r, v)
+	easyjson1e840bfDecodeGithubComAnilibriaAliceInternalUtils4(&r, v)
 	return r.Error()
 }
 
 // UnmarshalEasyJSON supports easyjson.Unmarshaler interface
 func (v *ApiError) UnmarshalEasyJSON(l *jlexer.Lexer) {
-	easyjson1e840bfDecodeGithubComAnilibriaAliceInternalUtils3(l, v)
+	easyjson1e840bfDecodeGithubComAnilibriaAliceInternalUtils4(l, v)
 }
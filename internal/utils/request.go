@@ -10,10 +10,14 @@ const (
 	CTInvalid RequestContentType = iota
 	CTApplicationUrlencoded
 	CTMultipartFormData
+	CTApplicationJSON
+	CTURLQuery
 )
 
 type FastUserValue uint8
 
 const (
 	UVCacheKey FastUserValue = iota
+	UVRequestArgs
+	UVSession
 )